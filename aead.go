@@ -0,0 +1,221 @@
+// aead.go - public domain ChaCha20-Poly1305 authenticated encryption.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// See https://datatracker.ietf.org/doc/html/rfc8439#section-2.8
+// for a description of the AEAD construction, and
+// https://datatracker.ietf.org/doc/html/draft-irtf-cfrg-xchacha
+// for its XChaCha20-Poly1305 extension.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// AEADKeySize is the length in bytes of a ChaCha20-Poly1305 key.
+const AEADKeySize = 32
+
+// AEADNonceSize is the length in bytes of a ChaCha20-Poly1305 nonce.
+const AEADNonceSize = 12
+
+// XAEADNonceSize is the length in bytes of an XChaCha20-Poly1305 nonce.
+const XAEADNonceSize = 24
+
+// aeadMaxPlaintext is the longest message the 32-bit IETF block counter
+// can encrypt after block 0 is used for the Poly1305 key.
+const aeadMaxPlaintext = (1<<32 - 1) * blockLen
+
+var errOpen = errors.New("chacha20: message authentication failed")
+
+// chacha20poly1305 implements crypto/cipher.AEAD for RFC 8439
+// ChaCha20-Poly1305.
+type chacha20poly1305 struct {
+	ctx Ctx // keyed IETF context; a copy is used by each Seal and Open
+}
+
+// NewAEAD returns a ChaCha20-Poly1305 AEAD (RFC 8439 section 2.8) that
+// uses the 32-byte key.  It has a 12-byte nonce and a 16-byte tag, and its
+// output is byte-compatible with other RFC 8439 implementations.
+// Long messages are processed in parallel just as Encrypt processes them.
+// A nonce must never be used twice with the same key.
+// NewAEAD panics with ErrInvalidKeySize if len(key) is not 32; use
+// NewAEADWithError when key comes from untrusted input.
+//
+// The returned AEAD is safe for concurrent use.  Its Seal panics if the
+// plaintext is longer than 256 GiB - 64 bytes.
+func NewAEAD(key []byte) cipher.AEAD {
+	a, err := NewAEADWithError(key)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// NewAEADWithError is like NewAEAD but returns ErrInvalidKeySize instead
+// of panicking when key has an invalid length.
+func NewAEADWithError(key []byte) (cipher.AEAD, error) {
+	if len(key) != AEADKeySize {
+		return nil, ErrInvalidKeySize
+	}
+	a := &chacha20poly1305{}
+	a.ctx = *NewIETF(key, make([]byte, AEADNonceSize))
+	return a, nil
+}
+
+func (a *chacha20poly1305) NonceSize() int { return AEADNonceSize }
+
+func (a *chacha20poly1305) Overhead() int { return Poly1305TagSize }
+
+func (a *chacha20poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != AEADNonceSize {
+		panic("chacha20: invalid AEAD nonce length passed to Seal")
+	}
+	x := a.ctx
+	x.IvSetup(nonce)
+	return aeadSeal(&x, dst, plaintext, additionalData)
+}
+
+func (a *chacha20poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != AEADNonceSize {
+		panic("chacha20: invalid AEAD nonce length passed to Open")
+	}
+	x := a.ctx
+	x.IvSetup(nonce)
+	return aeadOpen(&x, dst, ciphertext, additionalData)
+}
+
+// xchacha20poly1305 implements crypto/cipher.AEAD for
+// XChaCha20-Poly1305.
+type xchacha20poly1305 struct {
+	key [AEADKeySize]byte
+	ctx Ctx // IETF context; a copy is keyed by each Seal and Open
+}
+
+// NewXAEAD returns an XChaCha20-Poly1305 AEAD (draft-irtf-cfrg-xchacha)
+// that uses the 32-byte key.  It has a 24-byte nonce, long enough to be
+// chosen at random with crypto/rand, and a 16-byte tag.  Each Seal and Open
+// derives a subkey with HChaCha20(key, nonce[:16]) and then applies
+// ChaCha20-Poly1305 as NewAEAD does, with the 12-byte nonce formed from
+// 4 zero bytes followed by nonce[16:].
+// NewXAEAD panics with ErrInvalidKeySize if len(key) is not 32; use
+// NewXAEADWithError when key comes from untrusted input.
+//
+// The returned AEAD is safe for concurrent use.
+func NewXAEAD(key []byte) cipher.AEAD {
+	a, err := NewXAEADWithError(key)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// NewXAEADWithError is like NewXAEAD but returns ErrInvalidKeySize instead
+// of panicking when key has an invalid length.
+func NewXAEADWithError(key []byte) (cipher.AEAD, error) {
+	if len(key) != AEADKeySize {
+		return nil, ErrInvalidKeySize
+	}
+	a := &xchacha20poly1305{}
+	copy(a.key[:], key)
+	a.ctx = *NewIETF(key, make([]byte, AEADNonceSize))
+	return a, nil
+}
+
+func (a *xchacha20poly1305) NonceSize() int { return XAEADNonceSize }
+
+func (a *xchacha20poly1305) Overhead() int { return Poly1305TagSize }
+
+func (a *xchacha20poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != XAEADNonceSize {
+		panic("chacha20: invalid XAEAD nonce length passed to Seal")
+	}
+	x := a.ctx
+	a.setup(&x, nonce)
+	return aeadSeal(&x, dst, plaintext, additionalData)
+}
+
+func (a *xchacha20poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != XAEADNonceSize {
+		panic("chacha20: invalid XAEAD nonce length passed to Open")
+	}
+	x := a.ctx
+	a.setup(&x, nonce)
+	return aeadOpen(&x, dst, ciphertext, additionalData)
+}
+
+// setup keys IETF context x with the HChaCha20 subkey for nonce and sets
+// its 12-byte nonce.
+func (a *xchacha20poly1305) setup(x *Ctx, nonce []byte) {
+	var n [AEADNonceSize]byte
+	x.KeySetup(HChaCha20(a.key[:], nonce[:16]))
+	copy(n[4:], nonce[16:])
+	x.IvSetup(n[:])
+}
+
+// aeadSeal encrypts and authenticates plaintext with x, which must be
+// keyed and have its nonce set, appending the result to dst.
+func aeadSeal(x *Ctx, dst, plaintext, additionalData []byte) []byte {
+	if uint64(len(plaintext)) > aeadMaxPlaintext {
+		panic("chacha20: plaintext too large for AEAD")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+Poly1305TagSize)
+	otk := x.Poly1305Key()
+	x.Encrypt(plaintext, out)
+	copy(out[len(plaintext):], aeadTag(otk, additionalData, out[:len(plaintext)]))
+	return ret
+}
+
+// aeadOpen authenticates and decrypts ciphertext with x, which must be
+// keyed and have its nonce set, appending the result to dst.  Nothing is
+// decrypted unless the tag is valid.
+func aeadOpen(x *Ctx, dst, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < Poly1305TagSize ||
+		uint64(len(ciphertext)) > aeadMaxPlaintext+Poly1305TagSize {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-Poly1305TagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-Poly1305TagSize]
+	otk := x.Poly1305Key()
+	if subtle.ConstantTimeCompare(aeadTag(otk, additionalData, ciphertext), tag) != 1 {
+		return nil, errOpen
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	x.Encrypt(ciphertext, out)
+	return ret, nil
+}
+
+// aeadTag returns the Poly1305 tag of additionalData and ciphertext as laid
+// out by RFC 8439 section 2.8: each zero-padded to a multiple of 16 bytes,
+// followed by their lengths as 64-bit little-endian integers.
+func aeadTag(otk, additionalData, ciphertext []byte) []byte {
+	var pad [Poly1305TagSize]byte
+	var lengths [16]byte
+	p := NewPoly1305(otk)
+	p.Write(additionalData)
+	p.Write(pad[:(Poly1305TagSize-len(additionalData)%Poly1305TagSize)%Poly1305TagSize])
+	p.Write(ciphertext)
+	p.Write(pad[:(Poly1305TagSize-len(ciphertext)%Poly1305TagSize)%Poly1305TagSize])
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	p.Write(lengths[:])
+	return p.Sum(nil)
+}
+
+// sliceForAppend extends in by n bytes, reallocating if needed.  head is
+// the whole extended slice and tail is its last n bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,119 @@
+// aead_test.go - test ChaCha20-Poly1305 authenticated encryption.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"testing"
+)
+
+func TestAEAD(t *testing.T) {
+	// RFC 8439 section 2.8.2 test vector.
+	key := unhex("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := unhex("070000004041424344454647")
+	ad := unhex("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, sunscreen " +
+		"would be it.")
+	want := unhex("d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+		"3ff4def08e4b7a9de576d26586cec64b6116" +
+		"1ae10b594f09e26a7e902ecbd0600691")
+
+	a := NewAEAD(key)
+	if a.NonceSize() != 12 || a.Overhead() != 16 {
+		t.Errorf("AEAD sizes: got %d, %d want 12, 16", a.NonceSize(), a.Overhead())
+	}
+	got := a.Seal(nil, nonce, plaintext, ad)
+	if !bytes.Equal(got, want) {
+		t.Errorf("AEAD Seal:\n got %x\nwant %x", got, want)
+	}
+	opened, err := a.Open(nil, nonce, got, ad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("AEAD Open: got %q, %v want %q, nil", opened, err, plaintext)
+	}
+
+	testAEADRoundTrip(t, "AEAD", a, nonce)
+}
+
+func TestXAEAD(t *testing.T) {
+	// draft-irtf-cfrg-xchacha-03 appendix A.3.1 test vector.
+	key := unhex("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := unhex("404142434445464748494a4b4c4d4e4f5051525354555657")
+	ad := unhex("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, sunscreen " +
+		"would be it.")
+	want := unhex("bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb" +
+		"731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b452" +
+		"2f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff9" +
+		"21f9664c97637da9768812f615c68b13b52e" +
+		"c0875924c1c7987947deafd8780acf49")
+
+	a := NewXAEAD(key)
+	if a.NonceSize() != 24 || a.Overhead() != 16 {
+		t.Errorf("XAEAD sizes: got %d, %d want 24, 16", a.NonceSize(), a.Overhead())
+	}
+	got := a.Seal(nil, nonce, plaintext, ad)
+	if !bytes.Equal(got, want) {
+		t.Errorf("XAEAD Seal:\n got %x\nwant %x", got, want)
+	}
+	opened, err := a.Open(nil, nonce, got, ad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("XAEAD Open: got %q, %v want %q, nil", opened, err, plaintext)
+	}
+
+	// Round trip with a random nonce, as XChaCha20-Poly1305 is meant to use.
+	crand.Read(nonce)
+	testAEADRoundTrip(t, "XAEAD", a, nonce)
+}
+
+// testAEADRoundTrip seals and opens messages short enough for serial and
+// long enough for parallel processing, in place and not, and checks that
+// any change to the ciphertext, additional data or nonce is detected.
+func testAEADRoundTrip(t *testing.T, name string, a cipher.AEAD, nonce []byte) {
+	sizes := []int{0, 1, 15, 16, 17, 63, 64, 65, 1000, 100_000, 1_000_003}
+	ad := []byte("additional data")
+	for k := 0; k < len(sizes); k++ {
+		m := make([]byte, sizes[k])
+		crand.Read(m)
+		c := a.Seal(nil, nonce, m, ad)
+		if len(c) != len(m)+a.Overhead() {
+			t.Errorf("%s size %d: ciphertext length %d", name, sizes[k], len(c))
+		}
+
+		// In-place Seal must match.
+		buf := make([]byte, len(m), len(m)+a.Overhead())
+		copy(buf, m)
+		if c2 := a.Seal(buf[:0], nonce, buf, ad); !bytes.Equal(c2, c) {
+			t.Errorf("%s size %d: in-place Seal differs", name, sizes[k])
+		}
+
+		got, err := a.Open([]byte("prefix"), nonce, c, ad)
+		if err != nil || !bytes.Equal(got[6:], m) || string(got[:6]) != "prefix" {
+			t.Errorf("%s size %d: Open failed: %v", name, sizes[k], err)
+		}
+
+		c[len(c)/2] ^= 0x40
+		if _, err := a.Open(nil, nonce, c, ad); err == nil {
+			t.Errorf("%s size %d: tampered ciphertext accepted", name, sizes[k])
+		}
+		c[len(c)/2] ^= 0x40
+		if _, err := a.Open(nil, nonce, c, ad[1:]); err == nil {
+			t.Errorf("%s size %d: wrong additional data accepted", name, sizes[k])
+		}
+		wrongNonce := append([]byte{}, nonce...)
+		wrongNonce[0] ^= 1
+		if _, err := a.Open(nil, wrongNonce, c, ad); err == nil {
+			t.Errorf("%s size %d: wrong nonce accepted", name, sizes[k])
+		}
+	}
+	if _, err := a.Open(nil, nonce, make([]byte, a.Overhead()-1), nil); err == nil {
+		t.Errorf("%s: short ciphertext accepted", name)
+	}
+}
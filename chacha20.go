@@ -80,10 +80,17 @@
 // after producing about 1.2 zettabytes if io.EOF is not honored.
 // A zettabyte is so much data that it is nearly impossible to generate
 // that much.  At 1 ns/block it would take 584+ years to generate 1.2 zettabytes.
+// SetExhaustionPolicy can make them return an error or rekey instead.
+//
+// NewIETF creates a context for the RFC 8439 variant of ChaCha20, which has a
+// 12-byte nonce and a 32-bit block counter.  Its key stream is exhausted
+// after 256 GiB.
 //
 // Some chacha20 methods also panic when the method's destination is
 // shorter than its source, or when an invalid length key or iv is given,
-// or when an invalid number of rounds is specified.
+// or when an invalid number of rounds is specified.  NewWithError,
+// KeySetupErr, IvSetupErr, SetRoundsErr and the like return errors
+// that errors.Is can match, such as ErrInvalidKeySize, instead.
 //
 // The Encrypt method processes slices over about 25,600 bytes long with
 // parallel processing at between 2 and 9 times the speed of mono-processing.
@@ -95,12 +102,26 @@
 // Processing speed then will be dramatically slower for long byte slices.
 // As an alternative, TuneParallel can also adjust memory allocation vs speed
 // for parallel processing, achieving 5.1 times the speed of non-parallel
-// processing with a minimal memory footprint.
+// processing with a minimal memory footprint.  UsePool makes a context
+// process its chunks with a Pool of long-lived workers, which several
+// contexts can share, instead of a goroutine per chunk.
+//
+// On amd64 an SSSE3 (4 blocks) or AVX2 (8 blocks) assembly core is chosen at
+// run time, so a single goroutine, including one using NewSmallMemory, runs
+// about 4 times as fast as the scalar core.  On arm64 a NEON core computes 4
+// blocks at once.  Build with -tags purego to use pure Go only.  Without
+// assembly a pure-Go core that computes 4 blocks side by side is used
+// whenever at least 256 bytes remain; its gain over computing them one at
+// a time is small, about 5% for Encrypt, and varies with the CPU.
 package chacha20
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/binary"
+	"errors"
 	"io"
+	"runtime"
 	"sync"
 )
 
@@ -109,6 +130,22 @@ import (
 // ChaCha12 requires 12, and ChaCha8 requires 8 rounds.
 const defaultRounds = 20
 
+// Errors returned by the error-returning variants of the constructors and
+// setup methods, such as NewWithError and KeySetupErr.  The panicking
+// variants, such as New and KeySetup, panic with these same values, so
+// errors.Is can match a recovered value too.
+var (
+	// ErrInvalidKeySize reports a key of the wrong length.
+	ErrInvalidKeySize = errors.New("chacha20: invalid key length")
+	// ErrInvalidNonceSize reports an iv (nonce) of the wrong length.
+	ErrInvalidNonceSize = errors.New("chacha20: invalid iv (nonce) length")
+	// ErrInvalidRounds reports a number of rounds other than 8, 12 or 20.
+	ErrInvalidRounds = errors.New("chacha20: invalid number of rounds; must be 8, 12 or 20")
+	// ErrKeystreamExhausted reports use of a context after its key stream
+	// was exhausted and io.EOF was returned.
+	ErrKeystreamExhausted = errors.New("chacha20: key stream is exhausted")
+)
+
 // Using individual variables instead of an array provides 32% faster code.
 func salsa20_wordtobyte(input []uint32, rounds int, output []byte) {
 	var t uint32
@@ -274,6 +311,128 @@ func salsa20_wordtobyte(input []uint32, rounds int, output []byte) {
 // ChaCha block length in bytes
 const blockLen = 64
 
+// maxMultiBlocks is the largest number of blocks any keystreamBlocks
+// implementation computes per call.
+const maxMultiBlocks = 8
+
+// multiBuf holds the output of keystreamBlocks.
+type multiBuf [maxMultiBlocks * blockLen]byte
+
+// quarterRound is the ChaCha quarter-round on words a, b, c and d.
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d ^= a
+	d = d<<16 | d>>16
+	c += d
+	b ^= c
+	b = b<<12 | b>>20
+	a += b
+	d ^= a
+	d = d<<8 | d>>24
+	c += d
+	b ^= c
+	b = b<<7 | b>>25
+	return a, b, c, d
+}
+
+// keystreamBlocks4Go puts 4 key stream blocks with counters input[12],
+// input[12]+1, ... into out[:256].  input[12] must not wrap.  Running the
+// 4 blocks' quarter-rounds side by side gives the compiler independent
+// instructions to schedule, which makes it slightly faster than 4 calls
+// to salsa20_wordtobyte on most CPUs.  It is used when no assembly core
+// is available.
+func keystreamBlocks4Go(input *[16]uint32, rounds int, out *multiBuf) {
+	a0, a1, a2, a3 := input[0], input[0], input[0], input[0]
+	b0, b1, b2, b3 := input[1], input[1], input[1], input[1]
+	c0, c1, c2, c3 := input[2], input[2], input[2], input[2]
+	d0, d1, d2, d3 := input[3], input[3], input[3], input[3]
+	e0, e1, e2, e3 := input[4], input[4], input[4], input[4]
+	f0, f1, f2, f3 := input[5], input[5], input[5], input[5]
+	g0, g1, g2, g3 := input[6], input[6], input[6], input[6]
+	h0, h1, h2, h3 := input[7], input[7], input[7], input[7]
+	i0, i1, i2, i3 := input[8], input[8], input[8], input[8]
+	j0, j1, j2, j3 := input[9], input[9], input[9], input[9]
+	k0, k1, k2, k3 := input[10], input[10], input[10], input[10]
+	l0, l1, l2, l3 := input[11], input[11], input[11], input[11]
+	m0, m1, m2, m3 := input[12], input[12]+1, input[12]+2, input[12]+3
+	n0, n1, n2, n3 := input[13], input[13], input[13], input[13]
+	o0, o1, o2, o3 := input[14], input[14], input[14], input[14]
+	p0, p1, p2, p3 := input[15], input[15], input[15], input[15]
+
+	for z := rounds; z > 0; z -= 2 {
+		// Column round.
+		a0, e0, i0, m0 = quarterRound(a0, e0, i0, m0)
+		a1, e1, i1, m1 = quarterRound(a1, e1, i1, m1)
+		a2, e2, i2, m2 = quarterRound(a2, e2, i2, m2)
+		a3, e3, i3, m3 = quarterRound(a3, e3, i3, m3)
+		b0, f0, j0, n0 = quarterRound(b0, f0, j0, n0)
+		b1, f1, j1, n1 = quarterRound(b1, f1, j1, n1)
+		b2, f2, j2, n2 = quarterRound(b2, f2, j2, n2)
+		b3, f3, j3, n3 = quarterRound(b3, f3, j3, n3)
+		c0, g0, k0, o0 = quarterRound(c0, g0, k0, o0)
+		c1, g1, k1, o1 = quarterRound(c1, g1, k1, o1)
+		c2, g2, k2, o2 = quarterRound(c2, g2, k2, o2)
+		c3, g3, k3, o3 = quarterRound(c3, g3, k3, o3)
+		d0, h0, l0, p0 = quarterRound(d0, h0, l0, p0)
+		d1, h1, l1, p1 = quarterRound(d1, h1, l1, p1)
+		d2, h2, l2, p2 = quarterRound(d2, h2, l2, p2)
+		d3, h3, l3, p3 = quarterRound(d3, h3, l3, p3)
+		// Diagonal round.
+		a0, f0, k0, p0 = quarterRound(a0, f0, k0, p0)
+		a1, f1, k1, p1 = quarterRound(a1, f1, k1, p1)
+		a2, f2, k2, p2 = quarterRound(a2, f2, k2, p2)
+		a3, f3, k3, p3 = quarterRound(a3, f3, k3, p3)
+		b0, g0, l0, m0 = quarterRound(b0, g0, l0, m0)
+		b1, g1, l1, m1 = quarterRound(b1, g1, l1, m1)
+		b2, g2, l2, m2 = quarterRound(b2, g2, l2, m2)
+		b3, g3, l3, m3 = quarterRound(b3, g3, l3, m3)
+		c0, h0, i0, n0 = quarterRound(c0, h0, i0, n0)
+		c1, h1, i1, n1 = quarterRound(c1, h1, i1, n1)
+		c2, h2, i2, n2 = quarterRound(c2, h2, i2, n2)
+		c3, h3, i3, n3 = quarterRound(c3, h3, i3, n3)
+		d0, e0, j0, o0 = quarterRound(d0, e0, j0, o0)
+		d1, e1, j1, o1 = quarterRound(d1, e1, j1, o1)
+		d2, e2, j2, o2 = quarterRound(d2, e2, j2, o2)
+		d3, e3, j3, o3 = quarterRound(d3, e3, j3, o3)
+	}
+
+	feedForward(out[0*blockLen:], input, 0,
+		a0, b0, c0, d0, e0, f0, g0, h0,
+		i0, j0, k0, l0, m0, n0, o0, p0)
+	feedForward(out[1*blockLen:], input, 1,
+		a1, b1, c1, d1, e1, f1, g1, h1,
+		i1, j1, k1, l1, m1, n1, o1, p1)
+	feedForward(out[2*blockLen:], input, 2,
+		a2, b2, c2, d2, e2, f2, g2, h2,
+		i2, j2, k2, l2, m2, n2, o2, p2)
+	feedForward(out[3*blockLen:], input, 3,
+		a3, b3, c3, d3, e3, f3, g3, h3,
+		i3, j3, k3, l3, m3, n3, o3, p3)
+}
+
+// feedForward adds the initial state to words a-p of a block whose counter
+// is input[12]+ctr and stores them little-endian in out[:64].
+func feedForward(out []byte, input *[16]uint32, ctr uint32,
+	a, b, c, d, e, f, g, h, i, j, k, l, m, n, o, p uint32) {
+	out = out[:blockLen]
+	binary.LittleEndian.PutUint32(out[0:], a+input[0])
+	binary.LittleEndian.PutUint32(out[4:], b+input[1])
+	binary.LittleEndian.PutUint32(out[8:], c+input[2])
+	binary.LittleEndian.PutUint32(out[12:], d+input[3])
+	binary.LittleEndian.PutUint32(out[16:], e+input[4])
+	binary.LittleEndian.PutUint32(out[20:], f+input[5])
+	binary.LittleEndian.PutUint32(out[24:], g+input[6])
+	binary.LittleEndian.PutUint32(out[28:], h+input[7])
+	binary.LittleEndian.PutUint32(out[32:], i+input[8])
+	binary.LittleEndian.PutUint32(out[36:], j+input[9])
+	binary.LittleEndian.PutUint32(out[40:], k+input[10])
+	binary.LittleEndian.PutUint32(out[44:], l+input[11])
+	binary.LittleEndian.PutUint32(out[48:], m+input[12]+ctr)
+	binary.LittleEndian.PutUint32(out[52:], n+input[13])
+	binary.LittleEndian.PutUint32(out[56:], o+input[14])
+	binary.LittleEndian.PutUint32(out[60:], p+input[15])
+}
+
 // Tuneable parameters; can be set programmatically via TuneParallel.
 
 // Limit memory allocation to 51.6 KB by limiting the number of simultaneous
@@ -300,27 +459,87 @@ type Ctx struct {
 	output         [blockLen]byte
 	next           int
 	eof            bool
+	ietf           bool // 96-bit nonce and 32-bit block counter (RFC 8439)
+	policy         ExhaustionPolicy
+	rekey          RekeyFunc
+	err            error // exhaustion error XORKeyStream or Keystream couldn't return
 	rounds         int
 	parallel       bool
 	blocksPerChunk int
 	goroutinesMax  int
+	minParallel    int // if > 0, the fewest bytes processed in parallel
 	guard          chan struct{}
+	pool           *Pool // if not nil, processes parallel chunks
+}
+
+// newCtx allocates a context with default settings and no key or iv.
+// The parallel processing settings are the package defaults; see
+// SetDefaultTuneParams.
+func newCtx() *Ctx {
+	d := DefaultTuneParams()
+	return &Ctx{
+		next:           blockLen,
+		rounds:         defaultRounds,
+		parallel:       runtime.GOMAXPROCS(0) > d.SerialCutoff,
+		blocksPerChunk: d.BlocksPerChunk,
+		goroutinesMax:  d.MaxGoroutines,
+		minParallel:    d.MinParallelSize,
+		guard:          make(chan struct{}, d.MaxGoroutines),
+	}
 }
 
 // New allocates a new ChaCha20 context and sets it up
 // with the caller's key and iv.  The default number of rounds is 20.  To
 // use a different number of rounds, call SetRounds also.
 func New(key, iv []byte) (ctx *Ctx) {
-	ctx = &Ctx{
-		next:           blockLen,
-		rounds:         defaultRounds,
-		parallel:       true,
-		blocksPerChunk: blocksPerChunk,
-		goroutinesMax:  maxGoroutines,
-		guard:          make(chan struct{}, maxGoroutines),
+	ctx, err := NewWithError(key, iv)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// NewWithError is like New but returns ErrInvalidKeySize or
+// ErrInvalidNonceSize instead of panicking when key or iv has an invalid
+// length.  Use it when key or iv come from untrusted input.
+func NewWithError(key, iv []byte) (ctx *Ctx, err error) {
+	ctx = newCtx()
+	if err = ctx.KeySetupErr(key); err != nil {
+		return nil, err
+	}
+	if err = ctx.IvSetupErr(iv); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// NewIETF allocates a new ChaCha20 context that uses the IETF variant of
+// RFC 8439: a 12-byte nonce and a 32-bit block counter, as used by TLS 1.3
+// and QUIC.  NewIETF panics if len(key) is not 32 or len(nonce) is not 12.
+// The key stream is exhausted after 256 GiB, which Encrypt reports with
+// io.EOF.  Seek and GetCounter use the 32-bit block counter; IvSetup
+// requires a 12-byte nonce.  The default number of rounds is 20.
+func NewIETF(key, nonce []byte) (ctx *Ctx) {
+	ctx, err := NewIETFWithError(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// NewIETFWithError is like NewIETF but returns ErrInvalidKeySize or
+// ErrInvalidNonceSize instead of panicking when key or nonce has an invalid
+// length.
+func NewIETFWithError(key, nonce []byte) (ctx *Ctx, err error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	ctx = newCtx()
+	ctx.ietf = true
+	ctx.KeySetupErr(key)
+	if err = ctx.IvSetupErr(nonce); err != nil {
+		return nil, err
 	}
-	ctx.KeySetup(key)
-	ctx.IvSetup(iv)
 	return
 }
 
@@ -341,10 +560,24 @@ func NewSmallMemory(key, iv []byte) (ctx *Ctx) {
 // of rounds is 20.  Smaller r values are likely less secure but are faster.
 // ChaCha8 requires 8 rounds, ChaCha12 requires 12 and ChaCha20 requires 20.
 func (x *Ctx) SetRounds(r int) {
-	if !(r == 8 || r == 12 || r == 20) {
-		panic("chacha20:SetRounds: invalid number of rounds")
+	if err := x.SetRoundsErr(r); err != nil {
+		panic(err)
+	}
+}
+
+// SetRoundsErr is like SetRounds but returns ErrInvalidRounds instead of
+// panicking when r is not 8, 12 or 20.  x is unchanged on error.
+func (x *Ctx) SetRoundsErr(r int) error {
+	if !validRounds(r) {
+		return ErrInvalidRounds
 	}
 	x.rounds = r
+	return nil
+}
+
+// validRounds reports whether r is a valid number of rounds: 8, 12 or 20.
+func validRounds(r int) bool {
+	return r == 8 || r == 12 || r == 20
 }
 
 var sigma = []byte("expand 32-byte k")
@@ -354,11 +587,19 @@ var tau = []byte("expand 16-byte k")
 // KeySetup panics if len(key) is not 16 or 32. A key length of 32 is
 // recommended.
 func (x *Ctx) KeySetup(key []byte) {
+	if err := x.KeySetupErr(key); err != nil {
+		panic(err)
+	}
+}
+
+// KeySetupErr is like KeySetup but returns ErrInvalidKeySize instead of
+// panicking when len(key) is not 16 or 32.  x is unchanged on error.
+func (x *Ctx) KeySetupErr(key []byte) error {
 	var constants []byte
 	kbytes := len(key)
 
 	if kbytes != 16 && kbytes != 32 {
-		panic("chacha20: invalid key length; must be 16 or 32 bytes.")
+		return ErrInvalidKeySize
 	}
 
 	x.input[4] = binary.LittleEndian.Uint32(key[0:])
@@ -381,25 +622,50 @@ func (x *Ctx) KeySetup(key []byte) {
 	x.input[1] = binary.LittleEndian.Uint32(constants[4:])
 	x.input[2] = binary.LittleEndian.Uint32(constants[8:])
 	x.input[3] = binary.LittleEndian.Uint32(constants[12:])
+	return nil
 }
 
 // IvSetup sets initialization vector iv as a nonce for ChaCha20 context x.
 // It also calls Seek(0).
-// IvSetup panics if len(iv) is not 8.
+// IvSetup panics if len(iv) is not 8, or not 12 for a context created
+// with NewIETF.
 func (x *Ctx) IvSetup(iv []byte) {
+	if err := x.IvSetupErr(iv); err != nil {
+		panic(err)
+	}
+}
+
+// IvSetupErr is like IvSetup but returns ErrInvalidNonceSize instead of
+// panicking when iv has an invalid length.  x is unchanged on error.
+func (x *Ctx) IvSetupErr(iv []byte) error {
+	if x.ietf {
+		if len(iv) != 12 {
+			return ErrInvalidNonceSize
+		}
+		x.Seek(0)
+		x.input[13] = binary.LittleEndian.Uint32(iv[0:])
+		x.input[14] = binary.LittleEndian.Uint32(iv[4:])
+		x.input[15] = binary.LittleEndian.Uint32(iv[8:])
+		return nil
+	}
 	if len(iv) != 8 {
-		panic("chacha20: invalid iv length; must be 8.")
+		return ErrInvalidNonceSize
 	}
 	x.Seek(0)
 	x.input[14] = binary.LittleEndian.Uint32(iv[0:])
 	x.input[15] = binary.LittleEndian.Uint32(iv[4:])
+	return nil
 }
 
 // IvSetupUint64 sets x's initialization vector (nonce) to the value in n.
-// It also calls Seek(0).
+// It also calls Seek(0).  For a context created with NewIETF the 12-byte
+// nonce is 4 zero bytes followed by n in little-endian order.
 func (x *Ctx) IvSetupUint64(n uint64) {
 	var b [8]byte
 	x.Seek(0)
+	if x.ietf {
+		x.input[13] = 0
+	}
 	binary.LittleEndian.PutUint64(b[:], n)
 	x.input[14] = binary.LittleEndian.Uint32(b[0:])
 	x.input[15] = binary.LittleEndian.Uint32(b[4:])
@@ -407,23 +673,100 @@ func (x *Ctx) IvSetupUint64(n uint64) {
 
 // Seek moves x directly to 64-byte block number n in constant time.
 // Seek(0) sets x back to its initial state.
+// For a context created with NewIETF the block counter is 32 bits, and Seek
+// panics if n > 0xffffffff.
 func (x *Ctx) Seek(n uint64) {
+	if x.ietf {
+		if n > 0xffffffff {
+			panic("chacha20.Seek: block number exceeds the 32-bit IETF counter")
+		}
+		x.input[12] = uint32(n)
+		x.eof = false
+		x.err = nil
+		x.next = blockLen
+		return
+	}
 	var b [8]byte
 	binary.LittleEndian.PutUint64(b[:], n)
 	x.input[12] = binary.LittleEndian.Uint32(b[0:])
 	x.input[13] = binary.LittleEndian.Uint32(b[4:])
 	x.eof = false
+	x.err = nil
 	x.next = blockLen
 }
 
+// SeekByte moves x directly to byte offset in its key stream in constant
+// time, so that the next byte Encrypt, Read or XORKeyStream uses is key
+// stream byte offset.  Unlike Seek it doesn't require a block boundary;
+// within a block it computes that block, as Encrypt would have.
+// For a context created with NewIETF SeekByte panics if offset > 256 GiB;
+// offset 256 GiB leaves x at the end of its key stream.
+func (x *Ctx) SeekByte(offset uint64) {
+	blk, r := offset/blockLen, int(offset%blockLen)
+	if x.ietf && blk == 1<<32 && r == 0 {
+		x.Seek(0xffffffff)
+		x.input[12] = 0
+		x.eof = true // exhausted, as after its last block
+		return
+	}
+	x.Seek(blk)
+	if r > 0 {
+		salsa20_wordtobyte(x.input[:], x.rounds, x.output[:])
+		x.incCounter()
+		x.next = r
+	}
+}
+
+// TellByte returns x's byte offset in its key stream: the offset of the
+// next key stream byte Encrypt, Read or XORKeyStream would use.  It is the
+// inverse of SeekByte.  For a context that isn't from NewIETF the offset
+// wraps beyond 2^64 bytes, where only Seek can put x.
+func (x *Ctx) TellByte() uint64 {
+	blocks := x.GetCounter() // blocks started so far
+	if x.eof {
+		blocks = x.maxCounter() + 1
+	}
+	if x.next < blockLen {
+		return (blocks-1)*blockLen + uint64(x.next)
+	}
+	return blocks * blockLen
+}
+
 // GetCounter returns x's block counter value.
 func (x *Ctx) GetCounter() (n uint64) {
+	if x.ietf {
+		return uint64(x.input[12])
+	}
 	var b [8]byte
 	binary.LittleEndian.PutUint32(b[0:], x.input[12])
 	binary.LittleEndian.PutUint32(b[4:], x.input[13])
 	return binary.LittleEndian.Uint64(b[:])
 }
 
+// maxCounter returns the largest block counter value x can use.
+func (x *Ctx) maxCounter() uint64 {
+	if x.ietf {
+		return 0xffffffff
+	}
+	return 0xffffffffffffffff
+}
+
+// incCounter advances x's block counter by one.  It sets x.eof when the
+// counter wraps to zero, i.e. when the key stream is exhausted.
+func (x *Ctx) incCounter() {
+	x.input[12]++
+	if x.input[12] == 0 {
+		if x.ietf {
+			x.eof = true
+			return
+		}
+		x.input[13]++
+		if x.input[13] == 0 {
+			x.eof = true
+		}
+	}
+}
+
 // UseParallel accepts a boolean to determine whether x uses parallel
 // processing.  Parallel operation uses larger amounts of memory; if
 // memory is scarce call UseParallel with b false after calling New.
@@ -438,7 +781,8 @@ func (x *Ctx) UseParallel(b bool) {
 // circumstances it allows adjustments to parallel processing parameters
 // to make time and space tradeoffs.  Each Ctx instance has its own
 // parallel processing parameters.
-// Defaults are equivalent to TuneParallel(200, 300).
+// Defaults are equivalent to TuneParallel(200, 300), unless changed by
+// SetDefaultTuneParams.  AutoTune measures suitable values for the host.
 //
 // TuneParallel has no effect on processing short messages, or when parallel
 // processing is disabled by calling UseParallel(false) or NewSmallMemory.
@@ -469,10 +813,84 @@ func (x *Ctx) TuneParallel(BlocksPerGoroutine, MaxGoroutines int) {
 	}
 
 	if MaxGoroutines > 0 {
+		x.goroutinesMax = MaxGoroutines
 		x.guard = make(chan struct{}, MaxGoroutines)
 	}
 }
 
+// ExhaustionPolicy determines what a Ctx does when it is used after its key
+// stream is exhausted.  See SetExhaustionPolicy.
+type ExhaustionPolicy int
+
+const (
+	// ExhaustPanic makes Encrypt, Decrypt, Read, XORKeyStream and Keystream
+	// panic with ErrKeystreamExhausted when x is used after io.EOF was
+	// returned.  It is the default.
+	ExhaustPanic ExhaustionPolicy = iota
+
+	// ExhaustError makes Encrypt, Decrypt and Read return
+	// ErrKeystreamExhausted when x is used after io.EOF was returned.
+	// XORKeyStream and Keystream, which cannot return an error, zero
+	// the part of their output they could not produce and record
+	// ErrKeystreamExhausted for Err.
+	ExhaustError
+
+	// ExhaustRekey makes x call the RekeyFunc given to SetExhaustionPolicy
+	// as soon as its key stream is exhausted, and continue with the new key
+	// and iv at block 0.  Encrypt, Decrypt and Read then never return io.EOF.
+	// If the RekeyFunc fails its error is handled as ExhaustError handles
+	// ErrKeystreamExhausted.
+	ExhaustRekey
+)
+
+// RekeyFunc supplies a new key and iv for a Ctx whose key stream is
+// exhausted.  The key and iv must be valid for KeySetup and IvSetup; a key
+// and iv pair must never be reused.
+type RekeyFunc func() (key, iv []byte, err error)
+
+// SetExhaustionPolicy sets what x does when it is used after its key stream
+// is exhausted.  rekey is used only with ExhaustRekey, and must not be nil
+// then.  Each Ctx has its own policy; the default is ExhaustPanic.
+// SetExhaustionPolicy panics if p is not a valid ExhaustionPolicy or if
+// rekey is nil with ExhaustRekey.
+func (x *Ctx) SetExhaustionPolicy(p ExhaustionPolicy, rekey RekeyFunc) {
+	if p < ExhaustPanic || p > ExhaustRekey {
+		panic("chacha20.SetExhaustionPolicy: invalid policy")
+	}
+	if p == ExhaustRekey && rekey == nil {
+		panic("chacha20.SetExhaustionPolicy: ExhaustRekey requires a RekeyFunc")
+	}
+	x.policy = p
+	x.rekey = rekey
+}
+
+// Err returns the error, if any, that prevented the most recent
+// XORKeyStream or Keystream call from producing all of its output under
+// ExhaustError or ExhaustRekey; it is nil otherwise.  Seek and IvSetup clear
+// it.
+func (x *Ctx) Err() error {
+	return x.err
+}
+
+// exhausted applies x's ExhaustionPolicy to x's exhausted key stream.  It
+// returns nil only if x was rekeyed and can continue.
+func (x *Ctx) exhausted() error {
+	switch x.policy {
+	case ExhaustError:
+		return ErrKeystreamExhausted
+	case ExhaustRekey:
+		key, iv, err := x.rekey()
+		if err == nil {
+			err = x.KeySetupErr(key)
+		}
+		if err == nil {
+			err = x.IvSetupErr(iv)
+		}
+		return err
+	}
+	panic(ErrKeystreamExhausted)
+}
+
 // Encrypt puts ciphertext into c given plaintext m.  Any length is allowed
 // for m.  Parameters m and c must overlap completely or not at all.
 // Encrypt panics if len(c) < len(m).  len(c) can be greater than
@@ -480,26 +898,87 @@ func (x *Ctx) TuneParallel(BlocksPerGoroutine, MaxGoroutines int) {
 // in sequential segments with multiple calls to Encrypt.
 //
 // Encrypt returns io.EOF when the key stream is exhausted
-// (extremely improbable) after producing 1.2 zettabytes.
+// (extremely improbable) after producing 1.2 zettabytes, or 256 GiB for
+// a context created with NewIETF.
 // It will panic if called with the the same x after io.EOF is returned,
-// unless x has been re-initialized.
+// unless x has been re-initialized.  SetExhaustionPolicy can make it
+// return ErrKeystreamExhausted instead, or rekey x and continue.
 //
 // The same key, iv and rounds used to encrypt a message must be used to
 // decrypt the message.  Messages and Reads over about 25,600 bytes long will
 // be parallel processed 2-10 times as fast, unless NewSmallMemory is
 // used to allocate x.
 func (x *Ctx) Encrypt(m, c []byte) (n int, err error) {
+	if len(c) < len(m) {
+		panic("chacha20.Encrypt: insufficient space; c is shorter than m.")
+	}
+	return x.encryptDone(m, c, nil)
+}
+
+// EncryptContext is like Encrypt but stops early when ctx is cancelled:
+// once ctx is done it schedules no more parallel chunks, waits for those
+// already running, and returns the number of bytes n fully processed
+// together with ctx.Err().  x is then positioned exactly after those n
+// bytes, so a later call with m[n:] and c[n:] resumes where it stopped.
+// c[n:] is unchanged, except that an in-place c (same as m) holds
+// plaintext there.
+//
+// Cancellation is noticed between chunks of parallel processing and every
+// 64 KiB of serial processing.  EncryptContext panics if len(c) < len(m).
+func (x *Ctx) EncryptContext(ctx context.Context, m, c []byte) (n int, err error) {
+	if len(c) < len(m) {
+		panic("chacha20.EncryptContext: insufficient space; c is shorter than m.")
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	n, err = x.encryptDone(m, c, ctx.Done())
+	if err == errCanceled {
+		err = ctx.Err()
+	}
+	return
+}
+
+// errCanceled is returned by encrypt when its done channel is closed.
+var errCanceled = errors.New("chacha20: canceled")
+
+// cancelCheckLen is how many bytes encrypt processes serially between
+// checks of its done channel.
+const cancelCheckLen = 64 << 10
+
+// encryptDone does the work of Encrypt and EncryptContext, stopping with
+// errCanceled when done, if not nil, is closed.  It applies x's
+// ExhaustionPolicy.
+func (x *Ctx) encryptDone(m, c []byte, done <-chan struct{}) (n int, err error) {
 	size := len(m)
 	if size == 0 {
 		return
 	}
-	if len(c) < size {
-		panic("chacha20.Encrypt: insufficient space; c is shorter than m.")
+	for {
+		if x.eof && x.next >= blockLen {
+			if err = x.exhausted(); err != nil {
+				return
+			}
+		}
+		var k int
+		k, err = x.encrypt(m[n:], c[n:], done)
+		n += k
+		if err != io.EOF || x.policy != ExhaustRekey {
+			return // nil, or errCanceled with x positioned after n bytes
+		}
+		// Rekey now so the caller never sees io.EOF.
+		if err = x.exhausted(); err != nil || n >= size {
+			return
+		}
 	}
+}
+
+// encrypt does Encrypt's work for an x whose key stream is not exhausted.
+// It returns io.EOF if the key stream becomes exhausted, or errCanceled,
+// with x positioned after the n bytes processed, if done is closed.
+func (x *Ctx) encrypt(m, c []byte, done <-chan struct{}) (n int, err error) {
+	size := len(m)
 	idx := x.next
-	if x.eof && idx >= blockLen {
-		panic("chacha20: key stream is exhausted")
-	}
 
 	if x.parallel {
 
@@ -512,13 +991,7 @@ func (x *Ctx) Encrypt(m, c []byte) (n int, err error) {
 					break
 				}
 				salsa20_wordtobyte(x.input[:], x.rounds, x.output[:])
-				x.input[12]++
-				if x.input[12] == 0 {
-					x.input[13]++
-					if x.input[13] == 0 {
-						x.eof = true
-					}
-				}
+				x.incCounter()
 				idx = 0
 			}
 			c[n] = m[n] ^ x.output[idx]
@@ -539,30 +1012,43 @@ func (x *Ctx) Encrypt(m, c []byte) (n int, err error) {
 		// idx==blockLen must be true here.
 		var blocksPerChunk = x.blocksPerChunk
 		var chunkLen = blockLen * blocksPerChunk
-		if size-n > chunkLen*2 {
+		if x.parallelWorth(size-n, chunkLen) {
 			baseBlock := x.GetCounter()
 			chunkCount := uint64((size - n) / chunkLen) // how many chunks to process
-			if baseBlock+chunkCount*uint64(x.blocksPerChunk) > baseBlock {
-				// chunk processing won't reach keystream exhaustion (io.EOF)
+			endBlock := baseBlock + chunkCount*uint64(x.blocksPerChunk)
+			if endBlock > baseBlock && endBlock <= x.maxCounter() {
+				// chunk processing won't reach keystream exhaustion (io.EOF),
+				// for either the 64-bit or the 32-bit IETF block counter
 				wg := sync.WaitGroup{}
+				canceled := false
+				if x.pool != nil {
+					if queued, ok := x.pool.run(x, m, c, baseBlock, n, chunkCount,
+						blocksPerChunk, &wg, done); ok {
+						// The pool's workers process the chunks.
+						baseBlock += queued * uint64(blocksPerChunk)
+						n += int(queued) * chunkLen
+						canceled = queued < chunkCount
+						chunkCount = 0 // nothing left for goroutines
+					}
+				}
 				// DO NOT USE range.  IT BREAKS OLDER GO VERSIONS.
 				for chunk := uint64(0); chunk < chunkCount; chunk++ {
-					x.guard <- struct{}{} // blocks to limit simultaneous goroutines
+					if canceled = isDone(done); canceled {
+						break
+					}
+					// blocks to limit simultaneous goroutines
+					select {
+					case x.guard <- struct{}{}:
+					case <-done:
+						canceled = true
+					}
+					if canceled {
+						break
+					}
 					wg.Add(1)
 					go func(r Ctx, blk uint64, ni int) {
 						defer wg.Done()
-						r.Seek(blk)
-						for j := 0; j < blocksPerChunk; j++ {
-							salsa20_wordtobyte(r.input[:], r.rounds, r.output[:])
-							r.input[12]++
-							if r.input[12] == 0 {
-								r.input[13]++
-							}
-							for i := 0; i < blockLen; i++ {
-								c[ni] = m[ni] ^ r.output[i]
-								ni++
-							}
-						}
+						r.xorChunk(m, c, blk, ni, ni+chunkLen)
 						<-x.guard
 					}(*x, baseBlock, n)
 					baseBlock += uint64(blocksPerChunk)
@@ -572,29 +1058,46 @@ func (x *Ctx) Encrypt(m, c []byte) (n int, err error) {
 				x.Seek(baseBlock)
 				x.next = blockLen
 				idx = blockLen
+				if canceled {
+					err = errCanceled
+					return
+				}
 			}
 		}
 
 	} // if x.parallel
 
 	// ======= process all bytes left over after chunk processing  =======
-	for ; n < size; n++ {
-		if idx >= blockLen {
-			if x.eof {
-				break
+	// Whole groups of blocks go through the multi-block core.  With a done
+	// channel, it is checked every cancelCheckLen bytes.
+	for n < size {
+		end := size
+		if done != nil {
+			if isDone(done) {
+				x.next = idx
+				err = errCanceled
+				return
 			}
-			salsa20_wordtobyte(x.input[:], x.rounds, x.output[:])
-			x.input[12]++
-			if x.input[12] == 0 {
-				x.input[13]++
-				if x.input[13] == 0 {
-					x.eof = true
+			end = min(size, n+cancelCheckLen)
+		}
+		for ; n < end; n++ {
+			if idx >= blockLen {
+				if x.eof {
+					break
 				}
+				if n = x.xorBlocks(m[:end], c, n); n >= end {
+					break
+				}
+				salsa20_wordtobyte(x.input[:], x.rounds, x.output[:])
+				x.incCounter()
+				idx = 0
 			}
-			idx = 0
+			c[n] = m[n] ^ x.output[idx]
+			idx++
+		}
+		if x.eof && idx >= blockLen {
+			break
 		}
-		c[n] = m[n] ^ x.output[idx]
-		idx++
 	}
 	x.next = idx
 
@@ -604,6 +1107,60 @@ func (x *Ctx) Encrypt(m, c []byte) (n int, err error) {
 	return
 }
 
+// isDone reports whether done is closed.  A nil done is never closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// parallelWorth reports whether remaining bytes should be processed in
+// chunks of chunkLen bytes in parallel: there must be more than 2 chunks,
+// or, if x.minParallel is set, at least x.minParallel bytes and 1 chunk.
+func (x *Ctx) parallelWorth(remaining, chunkLen int) bool {
+	if x.minParallel > 0 {
+		return remaining >= x.minParallel && remaining >= chunkLen
+	}
+	return remaining > chunkLen*2
+}
+
+// xorChunk XORs m[ni:end] with x's key stream starting at block blk into
+// c[ni:end].  It is run on a copy of a Ctx for each chunk of parallel
+// processing.
+func (x *Ctx) xorChunk(m, c []byte, blk uint64, ni, end int) {
+	x.Seek(blk)
+	for ni < end {
+		if ni = x.xorBlocks(m[:end], c, ni); ni >= end {
+			break
+		}
+		salsa20_wordtobyte(x.input[:], x.rounds, x.output[:])
+		x.incCounter()
+		for i := 0; i < blockLen; i++ {
+			c[ni] = m[ni] ^ x.output[i]
+			ni++
+		}
+	}
+}
+
+// xorBlocks XORs m[n:] with x's key stream into c[n:], multiBlocks blocks
+// at a time using keystreamBlocks, for as long as a whole group of blocks
+// remains and x's low 32-bit counter word won't wrap within the group.
+// x must be on a block boundary.  xorBlocks returns the new value of n.
+func (x *Ctx) xorBlocks(m, c []byte, n int) int {
+	var buf multiBuf
+	groupLen := multiBlocks * blockLen
+	for len(m)-n >= groupLen && x.input[12] <= 0xffffffff-uint32(multiBlocks) {
+		keystreamBlocks(&x.input, x.rounds, &buf)
+		subtle.XORBytes(c[n:n+groupLen], m[n:n+groupLen], buf[:groupLen])
+		x.input[12] += uint32(multiBlocks)
+		n += groupLen
+	}
+	return n
+}
+
 // Decrypt puts plaintext into m given ciphertext c.  Any length is allowed
 // for c.  Parameters m and c must overlap completely or not at all.
 // Decrypt panics if len(m) < len(c).  len(m) can be larger than
@@ -613,13 +1170,10 @@ func (x *Ctx) Encrypt(m, c []byte) (n int, err error) {
 // Decrypt returns io.EOF when the key stream is exhausted
 // after producing 1.2 zettabytes.  It will panic if called with the
 // the same x after io.EOF is returned, unless x has been
-// re-initialized.
+// re-initialized or SetExhaustionPolicy says otherwise.
 // The same key, iv and rounds used to encrypt a message must be used
 // to decrypt the message.
 func (x *Ctx) Decrypt(c, m []byte) (int, error) {
-	if x.eof && x.next >= blockLen {
-		panic("chacha20.Decrypt: key stream is exhausted")
-	}
 	if len(m) < len(c) {
 		panic("chacha20.Decrypt: insufficient space; m is shorter than c.")
 	}
@@ -628,14 +1182,12 @@ func (x *Ctx) Decrypt(c, m []byte) (int, error) {
 
 // Keystream fills stream with cryptographically secure pseudorandom bytes
 // from x's key stream when a random key and iv are used.  Keystream
-// panics when the ChaCha key stream is exhausted after producing 1.2 zettabytes.
+// panics when the ChaCha key stream is exhausted after producing 1.2 zettabytes,
+// unless SetExhaustionPolicy says otherwise; then check Err.
 func (x *Ctx) Keystream(stream []byte) {
-	if x.eof && len(stream) >= blockLen {
-		panic("chacha20.Keystream: key stream is exhausted")
-	}
 	/// t := make([]byte, len(stream)) // 3X faster than zeroing stream first
 	clear(stream)
-	x.Encrypt(stream, stream)
+	x.XORKeyStream(stream, stream)
 }
 
 // The idea for adding XORKeyStream and Read came from skeeto's public
@@ -645,15 +1197,52 @@ func (x *Ctx) Keystream(stream []byte) {
 // XORKeyStream implements the crypto/cipher.Stream interface.
 // XORKeyStream XORs src bytes with ChaCha's key stream and puts the result
 // in dst.  XORKeyStream panics if len(dst) is less than len(src), or
-// when the ChaCha key stream is exhausted after producing 1.2 zettabytes.
+// when the ChaCha key stream is exhausted after producing 1.2 zettabytes,
+// unless SetExhaustionPolicy says otherwise.  If the key stream runs out,
+// the part of dst that could not be produced is zeroed, so that plaintext
+// is never left behind in it, and Err reports why under ExhaustError or
+// ExhaustRekey.
 func (x *Ctx) XORKeyStream(dst, src []byte) {
-	if x.eof && len(src) >= blockLen {
-		panic("chacha20.XORKeyStream: key stream is exhausted")
-	}
 	if len(dst) < len(src) {
 		panic("chacha20.XORKeyStream: insufficient space; dst is shorter than src.")
 	}
-	x.Encrypt(src, dst)
+	n, err := x.Encrypt(src, dst)
+	if n < len(src) {
+		clear(dst[n:len(src)])
+		if err == io.EOF {
+			err = ErrKeystreamExhausted
+		}
+		if x.policy != ExhaustPanic {
+			x.err = err
+		}
+	}
+}
+
+// XORKeyStreamAt XORs src bytes with x's key stream starting at key stream
+// byte offset and puts the result in dst, as SeekByte(offset) followed by
+// XORKeyStream would, but without using or changing x's position.  offset
+// need not be on a block boundary.  Long slices are parallel processed as
+// Encrypt does them.
+//
+// XORKeyStreamAt reads only x's key, iv, rounds and parallel processing
+// settings, so it is safe for any number of goroutines to call it at once
+// on the same x, e.g. to encrypt or decrypt the blocks of a device or the
+// byte ranges of a file, provided no method that changes x runs meanwhile.
+// It panics if len(dst) is less than len(src), or, for a context created
+// with NewIETF, if offset+len(src) is beyond the 256 GiB key stream.
+func (x *Ctx) XORKeyStreamAt(dst, src []byte, offset uint64) {
+	if len(dst) < len(src) {
+		panic("chacha20.XORKeyStreamAt: insufficient space; dst is shorter than src.")
+	}
+	if x.ietf && (offset > (x.maxCounter()+1)*blockLen ||
+		uint64(len(src)) > (x.maxCounter()+1)*blockLen-offset) {
+		panic(ErrKeystreamExhausted)
+	}
+	r := *x // x itself is only read
+	r.eof = false
+	r.policy = ExhaustPanic
+	r.SeekByte(offset)
+	r.encrypt(src, dst, nil)
 }
 
 // Read fills b with cryptographically secure pseudorandom bytes from x's
@@ -662,11 +1251,17 @@ func (x *Ctx) XORKeyStream(dst, src []byte) {
 // Read returns io.EOF when the key stream is exhausted after producing 1.2
 // zettabytes.  It will panic if called with the
 // the same x after io.EOF is returned, unless IvSetup is called with a new
-// value first.
+// value first or SetExhaustionPolicy says otherwise.
 func (x *Ctx) Read(b []byte) (int, error) {
-	if x.eof && len(b) >= blockLen {
-		panic("chacha20.Read: key stream is exhausted")
-	}
 	clear(b)
 	return x.Encrypt(b, b)
 }
+
+// ReadContext is like Read but stops early when ctx is cancelled, as
+// EncryptContext does.  It returns the number of bytes n of b filled with
+// key stream, with ctx.Err() if ctx stopped it; b[n:] is zeroed.  x is
+// positioned exactly after the n bytes.
+func (x *Ctx) ReadContext(ctx context.Context, b []byte) (int, error) {
+	clear(b)
+	return x.EncryptContext(ctx, b, b)
+}
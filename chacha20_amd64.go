@@ -0,0 +1,71 @@
+// chacha20_amd64.go - select an amd64 SIMD multi-block ChaCha core.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// The SSSE3 core computes 4 blocks per call and the AVX2 core 8 blocks.
+// The best one the CPU supports is chosen when the package is initialized.
+// Build with -tags purego to use only the pure-Go core.
+
+//go:build amd64 && !purego
+
+package chacha20
+
+// blocks4SSSE3 puts 4 key stream blocks with counters input[12],
+// input[12]+1, ... into out[:256].  input[12] must not wrap.
+//
+//go:noescape
+func blocks4SSSE3(input *[16]uint32, rounds int, out *multiBuf)
+
+// blocks8AVX2 puts 8 key stream blocks with counters input[12],
+// input[12]+1, ... into out.  input[12] must not wrap.
+//
+//go:noescape
+func blocks8AVX2(input *[16]uint32, rounds int, out *multiBuf)
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+func xgetbv() (eax, edx uint32)
+
+var hasSSSE3, hasAVX2 = detectCPU()
+
+// multiBlocks is the number of blocks keystreamBlocks computes per call.
+var multiBlocks = 4
+
+func init() {
+	if hasAVX2 {
+		multiBlocks = 8
+	}
+}
+
+// detectCPU reports whether the CPU supports SSSE3, and AVX2 with the
+// operating system saving the YMM registers.
+func detectCPU() (ssse3, avx2 bool) {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 1 {
+		return
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	ssse3 = ecx1&(1<<9) != 0
+	osxsave := ecx1&(1<<27) != 0
+	avx := ecx1&(1<<28) != 0
+	if maxID < 7 || !osxsave || !avx {
+		return
+	}
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	avx2 = ebx7&(1<<5) != 0
+	return
+}
+
+// keystreamBlocks puts multiBlocks key stream blocks with counters
+// input[12], input[12]+1, ... into out.  input[12] must not wrap.
+func keystreamBlocks(input *[16]uint32, rounds int, out *multiBuf) {
+	switch {
+	case hasAVX2:
+		blocks8AVX2(input, rounds, out)
+	case hasSSSE3:
+		blocks4SSSE3(input, rounds, out)
+	default:
+		keystreamBlocks4Go(input, rounds, out)
+	}
+}
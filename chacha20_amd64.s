@@ -0,0 +1,223 @@
+// chacha20_amd64.s - public domain SSSE3 and AVX2 ChaCha multi-block cores.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// Each function computes several consecutive ChaCha blocks at once with
+// the blocks side by side: vector register lane j holds a state word of
+// block j, whose counter is input[12]+j.  The 16 state vectors are kept
+// on the stack; each step of a double round loads the 8 vectors of two
+// quarter-rounds into registers, runs both quarter-rounds interleaved and
+// stores them back.  At the end the initial state is added (the
+// feed-forward) and the words are transposed back into block order.
+//
+// The counter in input[12] must not wrap within the blocks computed;
+// the Go caller guarantees that.
+
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// PSHUFB masks that rotate each 32-bit word left by 16 and by 8 bits.
+DATA ·rol16<>+0x00(SB)/8, $0x0504070601000302
+DATA ·rol16<>+0x08(SB)/8, $0x0D0C0F0E09080B0A
+DATA ·rol16<>+0x10(SB)/8, $0x0504070601000302
+DATA ·rol16<>+0x18(SB)/8, $0x0D0C0F0E09080B0A
+GLOBL ·rol16<>(SB), (NOPTR+RODATA), $32
+
+DATA ·rol8<>+0x00(SB)/8, $0x0605040702010003
+DATA ·rol8<>+0x08(SB)/8, $0x0E0D0C0F0A09080B
+DATA ·rol8<>+0x10(SB)/8, $0x0605040702010003
+DATA ·rol8<>+0x18(SB)/8, $0x0E0D0C0F0A09080B
+GLOBL ·rol8<>(SB), (NOPTR+RODATA), $32
+
+// Block counter offsets 0, 1, ..., 7 for the lanes.
+DATA ·lanes<>+0x00(SB)/8, $0x0000000100000000
+DATA ·lanes<>+0x08(SB)/8, $0x0000000300000002
+DATA ·lanes<>+0x10(SB)/8, $0x0000000500000004
+DATA ·lanes<>+0x18(SB)/8, $0x0000000700000006
+GLOBL ·lanes<>(SB), (NOPTR+RODATA), $32
+
+// ==================== SSSE3: 4 blocks ====================
+// Stack: state vectors at 0(SP), initial state at 256(SP).
+
+#define LOAD4(i, r) MOVOU ((i)*16)(SP), r
+#define STORE4(i, r) MOVOU r, ((i)*16)(SP)
+
+// Rotate X register r left by n bits using temporary t.
+#define ROTL4(n, r, t) \
+	MOVO r, t; PSLLL $n, t; PSRLL $(32-n), r; POR t, r
+
+// Two quarter-rounds on (X0,X1,X2,X3) and (X4,X5,X6,X7).
+// X14 and X15 hold the rol16 and rol8 masks; X12 and X13 are temporaries.
+#define QR4x2 \
+	PADDL X1, X0; PADDL X5, X4; \
+	PXOR X0, X3; PXOR X4, X7; \
+	PSHUFB X14, X3; PSHUFB X14, X7; \
+	PADDL X3, X2; PADDL X7, X6; \
+	PXOR X2, X1; PXOR X6, X5; \
+	ROTL4(12, X1, X12); ROTL4(12, X5, X13); \
+	PADDL X1, X0; PADDL X5, X4; \
+	PXOR X0, X3; PXOR X4, X7; \
+	PSHUFB X15, X3; PSHUFB X15, X7; \
+	PADDL X3, X2; PADDL X7, X6; \
+	PXOR X2, X1; PXOR X6, X5; \
+	ROTL4(7, X1, X12); ROTL4(7, X5, X13)
+
+#define STEP4(a1, b1, c1, d1, a2, b2, c2, d2) \
+	LOAD4(a1, X0); LOAD4(b1, X1); LOAD4(c1, X2); LOAD4(d1, X3); \
+	LOAD4(a2, X4); LOAD4(b2, X5); LOAD4(c2, X6); LOAD4(d2, X7); \
+	QR4x2; \
+	STORE4(a1, X0); STORE4(b1, X1); STORE4(c1, X2); STORE4(d1, X3); \
+	STORE4(a2, X4); STORE4(b2, X5); STORE4(c2, X6); STORE4(d2, X7)
+
+// Broadcast 32-bit word i of input (SI) to all lanes of its state vector
+// and its initial-state copy.
+#define INIT4(i) \
+	MOVL ((i)*4)(SI), AX; MOVQ AX, X0; PSHUFL $0, X0, X0; \
+	STORE4(i, X0); MOVOU X0, (256+(i)*16)(SP)
+
+// Add the initial state to words 4g..4g+3, transpose them into block order
+// and write them to out (DI).
+#define OUT4(g) \
+	LOAD4(4*g+0, X0); MOVOU (256+(4*g+0)*16)(SP), X4; PADDL X4, X0; \
+	LOAD4(4*g+1, X1); MOVOU (256+(4*g+1)*16)(SP), X4; PADDL X4, X1; \
+	LOAD4(4*g+2, X2); MOVOU (256+(4*g+2)*16)(SP), X4; PADDL X4, X2; \
+	LOAD4(4*g+3, X3); MOVOU (256+(4*g+3)*16)(SP), X4; PADDL X4, X3; \
+	MOVO X0, X4; PUNPCKLLQ X1, X4; PUNPCKHLQ X1, X0; \
+	MOVO X2, X5; PUNPCKLLQ X3, X5; PUNPCKHLQ X3, X2; \
+	MOVO X4, X1; PUNPCKLQDQ X5, X1; PUNPCKHQDQ X5, X4; \
+	MOVO X0, X3; PUNPCKLQDQ X2, X3; PUNPCKHQDQ X2, X0; \
+	MOVOU X1, (0*64+(g)*16)(DI); MOVOU X4, (1*64+(g)*16)(DI); \
+	MOVOU X3, (2*64+(g)*16)(DI); MOVOU X0, (3*64+(g)*16)(DI)
+
+// func blocks4SSSE3(input *[16]uint32, rounds int, out *multiBuf)
+TEXT ·blocks4SSSE3(SB), 0, $512-24
+	MOVQ input+0(FP), SI
+	MOVQ rounds+8(FP), CX
+	MOVQ out+16(FP), DI
+
+	INIT4(0); INIT4(1); INIT4(2); INIT4(3)
+	INIT4(4); INIT4(5); INIT4(6); INIT4(7)
+	INIT4(8); INIT4(9); INIT4(10); INIT4(11)
+	INIT4(12); INIT4(13); INIT4(14); INIT4(15)
+	LOAD4(12, X0)
+	MOVOU ·lanes<>(SB), X1
+	PADDL X1, X0
+	STORE4(12, X0)
+	MOVOU X0, (256+12*16)(SP)
+
+	MOVOU ·rol16<>(SB), X14
+	MOVOU ·rol8<>(SB), X15
+
+loop4:
+	STEP4(0, 4, 8, 12, 1, 5, 9, 13)
+	STEP4(2, 6, 10, 14, 3, 7, 11, 15)
+	STEP4(0, 5, 10, 15, 1, 6, 11, 12)
+	STEP4(2, 7, 8, 13, 3, 4, 9, 14)
+	SUBQ $2, CX
+	JA   loop4
+
+	OUT4(0); OUT4(1); OUT4(2); OUT4(3)
+	RET
+
+// ==================== AVX2: 8 blocks ====================
+// Stack: state vectors at 0(SP), initial state at 512(SP).
+
+#define LOAD8(i, r) VMOVDQU ((i)*32)(SP), r
+#define STORE8(i, r) VMOVDQU r, ((i)*32)(SP)
+
+// Rotate Y register r left by n bits using temporary t.
+#define ROTL8(n, r, t) \
+	VPSLLD $n, r, t; VPSRLD $(32-n), r, r; VPOR t, r, r
+
+// Two quarter-rounds on (Y0,Y1,Y2,Y3) and (Y4,Y5,Y6,Y7).
+// Y14 and Y15 hold the rol16 and rol8 masks; Y12 and Y13 are temporaries.
+#define QR8x2 \
+	VPADDD Y1, Y0, Y0; VPADDD Y5, Y4, Y4; \
+	VPXOR Y0, Y3, Y3; VPXOR Y4, Y7, Y7; \
+	VPSHUFB Y14, Y3, Y3; VPSHUFB Y14, Y7, Y7; \
+	VPADDD Y3, Y2, Y2; VPADDD Y7, Y6, Y6; \
+	VPXOR Y2, Y1, Y1; VPXOR Y6, Y5, Y5; \
+	ROTL8(12, Y1, Y12); ROTL8(12, Y5, Y13); \
+	VPADDD Y1, Y0, Y0; VPADDD Y5, Y4, Y4; \
+	VPXOR Y0, Y3, Y3; VPXOR Y4, Y7, Y7; \
+	VPSHUFB Y15, Y3, Y3; VPSHUFB Y15, Y7, Y7; \
+	VPADDD Y3, Y2, Y2; VPADDD Y7, Y6, Y6; \
+	VPXOR Y2, Y1, Y1; VPXOR Y6, Y5, Y5; \
+	ROTL8(7, Y1, Y12); ROTL8(7, Y5, Y13)
+
+#define STEP8(a1, b1, c1, d1, a2, b2, c2, d2) \
+	LOAD8(a1, Y0); LOAD8(b1, Y1); LOAD8(c1, Y2); LOAD8(d1, Y3); \
+	LOAD8(a2, Y4); LOAD8(b2, Y5); LOAD8(c2, Y6); LOAD8(d2, Y7); \
+	QR8x2; \
+	STORE8(a1, Y0); STORE8(b1, Y1); STORE8(c1, Y2); STORE8(d1, Y3); \
+	STORE8(a2, Y4); STORE8(b2, Y5); STORE8(c2, Y6); STORE8(d2, Y7)
+
+#define INIT8(i) \
+	VPBROADCASTD ((i)*4)(SI), Y0; STORE8(i, Y0); VMOVDQU Y0, (512+(i)*32)(SP)
+
+// Add the initial state to words 4g..4g+3 and transpose them.  The
+// unpack instructions work within 128-bit halves, so afterwards the low
+// half of each register holds words of block k and the high half words of
+// block k+4.
+#define OUT8(g) \
+	LOAD8(4*g+0, Y0); VPADDD (512+(4*g+0)*32)(SP), Y0, Y0; \
+	LOAD8(4*g+1, Y1); VPADDD (512+(4*g+1)*32)(SP), Y1, Y1; \
+	LOAD8(4*g+2, Y2); VPADDD (512+(4*g+2)*32)(SP), Y2, Y2; \
+	LOAD8(4*g+3, Y3); VPADDD (512+(4*g+3)*32)(SP), Y3, Y3; \
+	VPUNPCKLDQ Y1, Y0, Y4; VPUNPCKHDQ Y1, Y0, Y0; \
+	VPUNPCKLDQ Y3, Y2, Y5; VPUNPCKHDQ Y3, Y2, Y2; \
+	VPUNPCKLQDQ Y5, Y4, Y1; VPUNPCKHQDQ Y5, Y4, Y4; \
+	VPUNPCKLQDQ Y2, Y0, Y3; VPUNPCKHQDQ Y2, Y0, Y0; \
+	VMOVDQU X1, (0*64+(g)*16)(DI); VEXTRACTI128 $1, Y1, (4*64+(g)*16)(DI); \
+	VMOVDQU X4, (1*64+(g)*16)(DI); VEXTRACTI128 $1, Y4, (5*64+(g)*16)(DI); \
+	VMOVDQU X3, (2*64+(g)*16)(DI); VEXTRACTI128 $1, Y3, (6*64+(g)*16)(DI); \
+	VMOVDQU X0, (3*64+(g)*16)(DI); VEXTRACTI128 $1, Y0, (7*64+(g)*16)(DI)
+
+// func blocks8AVX2(input *[16]uint32, rounds int, out *multiBuf)
+TEXT ·blocks8AVX2(SB), 0, $1024-24
+	MOVQ input+0(FP), SI
+	MOVQ rounds+8(FP), CX
+	MOVQ out+16(FP), DI
+
+	INIT8(0); INIT8(1); INIT8(2); INIT8(3)
+	INIT8(4); INIT8(5); INIT8(6); INIT8(7)
+	INIT8(8); INIT8(9); INIT8(10); INIT8(11)
+	INIT8(12); INIT8(13); INIT8(14); INIT8(15)
+	LOAD8(12, Y0)
+	VPADDD ·lanes<>(SB), Y0, Y0
+	STORE8(12, Y0)
+	VMOVDQU Y0, (512+12*32)(SP)
+
+	VMOVDQU ·rol16<>(SB), Y14
+	VMOVDQU ·rol8<>(SB), Y15
+
+loop8:
+	STEP8(0, 4, 8, 12, 1, 5, 9, 13)
+	STEP8(2, 6, 10, 14, 3, 7, 11, 15)
+	STEP8(0, 5, 10, 15, 1, 6, 11, 12)
+	STEP8(2, 7, 8, 13, 3, 4, 9, 14)
+	SUBQ $2, CX
+	JA   loop8
+
+	OUT8(0); OUT8(1); OUT8(2); OUT8(3)
+	VZEROUPPER
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
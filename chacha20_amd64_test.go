@@ -0,0 +1,54 @@
+// chacha20_amd64_test.go - test the amd64 SIMD cores against the scalar core.
+
+//go:build amd64 && !purego
+
+package chacha20
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSIMDCores(t *testing.T) {
+	cores := []struct {
+		name   string
+		ok     bool
+		blocks int
+		f      func(*[16]uint32, int, *multiBuf)
+	}{
+		{"SSSE3", hasSSSE3, 4, blocks4SSSE3},
+		{"AVX2", hasAVX2, 8, blocks8AVX2},
+	}
+	rounds := []int{8, 12, 20}
+	var seed [64]byte
+	for k := 0; k < len(cores); k++ {
+		if !cores[k].ok {
+			t.Logf("%s not supported by this CPU; skipped", cores[k].name)
+			continue
+		}
+		for trial := 0; trial < 100; trial++ {
+			var input [16]uint32
+			crand.Read(seed[:])
+			for i := 0; i < 16; i++ {
+				input[i] = binary.LittleEndian.Uint32(seed[4*i:])
+			}
+			if trial == 0 {
+				input[12] = 0xffffffff - uint32(cores[k].blocks) // largest allowed
+			}
+			r := rounds[trial%len(rounds)]
+			var got, want multiBuf
+			cores[k].f(&input, r, &got)
+			s := input
+			for i := 0; i < cores[k].blocks; i++ {
+				salsa20_wordtobyte(s[:], r, want[i*blockLen:])
+				s[12]++
+			}
+			if !bytes.Equal(got[:], want[:]) {
+				t.Fatalf("%s rounds %d trial %d:\n got %x\nwant %x",
+					cores[k].name, r, trial, got, want)
+			}
+		}
+	}
+}
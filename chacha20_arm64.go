@@ -0,0 +1,24 @@
+// chacha20_arm64.go - the arm64 NEON multi-block ChaCha core.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// NEON (Advanced SIMD) is part of every arm64 CPU, so no feature detection
+// is needed.  Build with -tags purego to use only the pure-Go core.
+
+//go:build arm64 && !purego
+
+package chacha20
+
+// blocks4NEON puts 4 key stream blocks with counters input[12],
+// input[12]+1, ... into out[:256].  input[12] must not wrap.
+//
+//go:noescape
+func blocks4NEON(input *[16]uint32, rounds int, out *multiBuf)
+
+// multiBlocks is the number of blocks keystreamBlocks computes per call.
+const multiBlocks = 4
+
+// keystreamBlocks puts multiBlocks key stream blocks with counters
+// input[12], input[12]+1, ... into out.  input[12] must not wrap.
+func keystreamBlocks(input *[16]uint32, rounds int, out *multiBuf) {
+	blocks4NEON(input, rounds, out)
+}
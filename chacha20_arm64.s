@@ -0,0 +1,112 @@
+// chacha20_arm64.s - public domain NEON ChaCha 4-block core.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// blocks4NEON computes 4 consecutive ChaCha blocks at once with the blocks
+// side by side: lane j of vector register Vi holds state word i of block
+// j, whose counter is input[12]+j.  All 16 state vectors stay in V0-V15
+// through the rounds, with V16-V19 as temporaries and V20 holding the
+// lane counter offsets.  At the end the initial state is reloaded and
+// added (the feed-forward) and the words are transposed back into block
+// order.
+//
+// The counter in input[12] must not wrap within the blocks computed;
+// the Go caller guarantees that.
+
+//go:build arm64 && !purego
+
+#include "textflag.h"
+
+// Block counter offsets 0, 1, 2, 3 for the lanes.
+DATA ·lanes<>+0x00(SB)/8, $0x0000000100000000
+DATA ·lanes<>+0x08(SB)/8, $0x0000000300000002
+GLOBL ·lanes<>(SB), (NOPTR+RODATA), $16
+
+// Rotate each word of b left by n bits through temporary t, where t
+// already holds the value to be rotated.
+#define ROTL(n, t, b) \
+	VSHL $(n), t.S4, b.S4; VSRI $(32-(n)), t.S4, b.S4
+
+// Four quarter-rounds at once, on (a0,b0,c0,d0) ... (a3,b3,c3,d3).
+#define QR4(a0, b0, c0, d0, a1, b1, c1, d1, a2, b2, c2, d2, a3, b3, c3, d3) \
+	VADD b0.S4, a0.S4, a0.S4; VADD b1.S4, a1.S4, a1.S4; \
+	VADD b2.S4, a2.S4, a2.S4; VADD b3.S4, a3.S4, a3.S4; \
+	VEOR a0.B16, d0.B16, d0.B16; VEOR a1.B16, d1.B16, d1.B16; \
+	VEOR a2.B16, d2.B16, d2.B16; VEOR a3.B16, d3.B16, d3.B16; \
+	VREV32 d0.H8, d0.H8; VREV32 d1.H8, d1.H8; \
+	VREV32 d2.H8, d2.H8; VREV32 d3.H8, d3.H8; \
+	VADD d0.S4, c0.S4, c0.S4; VADD d1.S4, c1.S4, c1.S4; \
+	VADD d2.S4, c2.S4, c2.S4; VADD d3.S4, c3.S4, c3.S4; \
+	VEOR c0.B16, b0.B16, V16.B16; VEOR c1.B16, b1.B16, V17.B16; \
+	VEOR c2.B16, b2.B16, V18.B16; VEOR c3.B16, b3.B16, V19.B16; \
+	ROTL(12, V16, b0); ROTL(12, V17, b1); \
+	ROTL(12, V18, b2); ROTL(12, V19, b3); \
+	VADD b0.S4, a0.S4, a0.S4; VADD b1.S4, a1.S4, a1.S4; \
+	VADD b2.S4, a2.S4, a2.S4; VADD b3.S4, a3.S4, a3.S4; \
+	VEOR a0.B16, d0.B16, V16.B16; VEOR a1.B16, d1.B16, V17.B16; \
+	VEOR a2.B16, d2.B16, V18.B16; VEOR a3.B16, d3.B16, V19.B16; \
+	ROTL(8, V16, d0); ROTL(8, V17, d1); \
+	ROTL(8, V18, d2); ROTL(8, V19, d3); \
+	VADD d0.S4, c0.S4, c0.S4; VADD d1.S4, c1.S4, c1.S4; \
+	VADD d2.S4, c2.S4, c2.S4; VADD d3.S4, c3.S4, c3.S4; \
+	VEOR c0.B16, b0.B16, V16.B16; VEOR c1.B16, b1.B16, V17.B16; \
+	VEOR c2.B16, b2.B16, V18.B16; VEOR c3.B16, b3.B16, V19.B16; \
+	ROTL(7, V16, b0); ROTL(7, V17, b1); \
+	ROTL(7, V18, b2); ROTL(7, V19, b3)
+
+// Transpose words (a,b,c,d) from lane order into block order and store
+// them at R4, R5, R6 and R7 (blocks 0-3), advancing each by 16 bytes.
+#define OUT4(a, b, c, d) \
+	VZIP1 b.S4, a.S4, V16.S4; VZIP2 b.S4, a.S4, V17.S4; \
+	VZIP1 d.S4, c.S4, V18.S4; VZIP2 d.S4, c.S4, V19.S4; \
+	VZIP1 V18.D2, V16.D2, a.D2; VZIP2 V18.D2, V16.D2, b.D2; \
+	VZIP1 V19.D2, V17.D2, c.D2; VZIP2 V19.D2, V17.D2, d.D2; \
+	VST1.P [a.B16], 16(R4); VST1.P [b.B16], 16(R5); \
+	VST1.P [c.B16], 16(R6); VST1.P [d.B16], 16(R7)
+
+// func blocks4NEON(input *[16]uint32, rounds int, out *multiBuf)
+TEXT ·blocks4NEON(SB), NOSPLIT, $0-24
+	MOVD input+0(FP), R0
+	MOVD rounds+8(FP), R1
+	MOVD out+16(FP), R4
+
+	// Broadcast each input word to all 4 lanes of V0-V15.
+	MOVD R0, R3
+	VLD4R.P 16(R3), [V0.S4, V1.S4, V2.S4, V3.S4]
+	VLD4R.P 16(R3), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VLD4R.P 16(R3), [V8.S4, V9.S4, V10.S4, V11.S4]
+	VLD4R (R3), [V12.S4, V13.S4, V14.S4, V15.S4]
+	MOVD $·lanes<>(SB), R3
+	VLD1 (R3), [V20.S4]
+	VADD V20.S4, V12.S4, V12.S4
+
+	LSR $1, R1
+loop:
+	QR4(V0, V4, V8, V12, V1, V5, V9, V13, V2, V6, V10, V14, V3, V7, V11, V15)
+	QR4(V0, V5, V10, V15, V1, V6, V11, V12, V2, V7, V8, V13, V3, V4, V9, V14)
+	SUBS $1, R1
+	BNE  loop
+
+	// Feed-forward: add the initial state again, 4 words at a time.
+	MOVD R0, R3
+	VLD4R.P 16(R3), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VADD V16.S4, V0.S4, V0.S4; VADD V17.S4, V1.S4, V1.S4
+	VADD V18.S4, V2.S4, V2.S4; VADD V19.S4, V3.S4, V3.S4
+	VLD4R.P 16(R3), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VADD V16.S4, V4.S4, V4.S4; VADD V17.S4, V5.S4, V5.S4
+	VADD V18.S4, V6.S4, V6.S4; VADD V19.S4, V7.S4, V7.S4
+	VLD4R.P 16(R3), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VADD V16.S4, V8.S4, V8.S4; VADD V17.S4, V9.S4, V9.S4
+	VADD V18.S4, V10.S4, V10.S4; VADD V19.S4, V11.S4, V11.S4
+	VLD4R (R3), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VADD V20.S4, V16.S4, V16.S4
+	VADD V16.S4, V12.S4, V12.S4; VADD V17.S4, V13.S4, V13.S4
+	VADD V18.S4, V14.S4, V14.S4; VADD V19.S4, V15.S4, V15.S4
+
+	ADD $64, R4, R5
+	ADD $128, R4, R6
+	ADD $192, R4, R7
+	OUT4(V0, V1, V2, V3)
+	OUT4(V4, V5, V6, V7)
+	OUT4(V8, V9, V10, V11)
+	OUT4(V12, V13, V14, V15)
+	RET
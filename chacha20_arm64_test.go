@@ -0,0 +1,42 @@
+// chacha20_arm64_test.go - test the arm64 NEON core against the scalar core.
+//
+// On an amd64 Linux build host with qemu-user installed, run with
+//	GOARCH=arm64 go test -exec qemu-aarch64 .
+
+//go:build arm64 && !purego
+
+package chacha20
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestNEONCore(t *testing.T) {
+	rounds := []int{8, 12, 20}
+	var seed [64]byte
+	for trial := 0; trial < 100; trial++ {
+		var input [16]uint32
+		crand.Read(seed[:])
+		for i := 0; i < 16; i++ {
+			input[i] = binary.LittleEndian.Uint32(seed[4*i:])
+		}
+		if trial == 0 {
+			input[12] = 0xffffffff - 4 // largest allowed
+		}
+		r := rounds[trial%len(rounds)]
+		var got, want multiBuf
+		blocks4NEON(&input, r, &got)
+		s := input
+		for i := 0; i < 4; i++ {
+			salsa20_wordtobyte(s[:], r, want[i*blockLen:])
+			s[12]++
+		}
+		if !bytes.Equal(got[:4*blockLen], want[:4*blockLen]) {
+			t.Fatalf("NEON rounds %d trial %d:\n got %x\nwant %x",
+				r, trial, got[:4*blockLen], want[:4*blockLen])
+		}
+	}
+}
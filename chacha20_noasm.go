@@ -0,0 +1,15 @@
+// chacha20_noasm.go - multi-block ChaCha core for builds without assembly.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+
+//go:build (!amd64 && !arm64) || purego
+
+package chacha20
+
+// multiBlocks is the number of blocks keystreamBlocks computes per call.
+const multiBlocks = 4
+
+// keystreamBlocks puts multiBlocks key stream blocks with counters
+// input[12], input[12]+1, ... into out.  input[12] must not wrap.
+func keystreamBlocks(input *[16]uint32, rounds int, out *multiBuf) {
+	keystreamBlocks4Go(input, rounds, out)
+}
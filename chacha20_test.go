@@ -10,11 +10,15 @@ package chacha20
 
 import (
 	"bytes"
+	"context"
 	"crypto/cipher"
 	crand "crypto/rand"
+	"errors"
 	"io"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Test interface compatability.
@@ -329,7 +333,7 @@ func init() {
 func BenchmarkChaCha_8rnds(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(8)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Encrypt(m5e6, m5e6)
 	}
 }
@@ -337,7 +341,7 @@ func BenchmarkChaCha_8rnds(b *testing.B) {
 func BenchmarkChaCha_12rnds(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(12)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Encrypt(m5e6, m5e6)
 	}
 }
@@ -345,7 +349,7 @@ func BenchmarkChaCha_12rnds(b *testing.B) {
 func BenchmarkChaCha_20rnds(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(20)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Encrypt(m5e6, m5e6)
 	}
 }
@@ -353,7 +357,7 @@ func BenchmarkChaCha_MaxSpeed(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(8)
 	ctx.TuneParallel(400, 300)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Encrypt(m5e6, m5e6)
 	}
 }
@@ -362,7 +366,7 @@ func BenchmarkChaCha_MinSpace(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(20)
 	ctx.TuneParallel(50, 30)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Encrypt(m5e6, m5e6)
 	}
 }
@@ -371,7 +375,7 @@ func BenchmarkChaCha_Read8rnds(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.TuneParallel(200, 300)
 	ctx.SetRounds(8)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Read(m5e6)
 	}
 }
@@ -379,7 +383,7 @@ func BenchmarkChaCha_Read8rnds(b *testing.B) {
 func BenchmarkChaCha_Read12rnds(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(12)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Read(m5e6)
 	}
 }
@@ -388,7 +392,7 @@ func BenchmarkChaCha_Read20rnds(b *testing.B) {
 	m5e6 := make([]byte, 5e6)
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(20)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Read(m5e6)
 	}
 }
@@ -397,7 +401,7 @@ func BenchmarkChaCha_Keystream20(b *testing.B) {
 	m5e6 := make([]byte, 5e6)
 	b.SetBytes(int64(len(m5e6)))
 	ctx.SetRounds(20)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctx.Keystream(m5e6)
 	}
 }
@@ -410,7 +414,7 @@ func BenchmarkOneBlock_XORStream(b *testing.B) {
 	b.SetBytes(int64(len(buf)))
 	c := New(key[:], iv[:])
 	c.SetRounds(20)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		c.XORKeyStream(buf[:], buf[:])
 	}
 }
@@ -419,7 +423,446 @@ func BenchmarkChaCha_SmallMemory20rnds(b *testing.B) {
 	b.SetBytes(int64(len(m5e6)))
 	ctxSmallMem := NewSmallMemory(key, iv)
 	ctxSmallMem.SetRounds(20)
-	for b.Loop() {
+	for i := 0; i < b.N; i++ {
 		ctxSmallMem.Encrypt(m5e6, m5e6)
 	}
 }
+
+func TestIETF(t *testing.T) {
+	// RFC 8439 section 2.4.2 test vector: key 00..1f, nonce
+	// 000000000000004a00000000, initial block counter 1.
+	key := make([]byte, 32)
+	for i := 0; i < len(key); i++ {
+		key[i] = byte(i)
+	}
+	nonce := []byte{0, 0, 0, 0, 0, 0, 0, 0x4a, 0, 0, 0, 0}
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, sunscreen " +
+		"would be it.")
+	want := []byte{
+		0x6e, 0x2e, 0x35, 0x9a, 0x25, 0x68, 0xf9, 0x80,
+		0x41, 0xba, 0x07, 0x28, 0xdd, 0x0d, 0x69, 0x81,
+		0xe9, 0x7e, 0x7a, 0xec, 0x1d, 0x43, 0x60, 0xc2,
+		0x0a, 0x27, 0xaf, 0xcc, 0xfd, 0x9f, 0xae, 0x0b,
+		0xf9, 0x1b, 0x65, 0xc5, 0x52, 0x47, 0x33, 0xab,
+		0x8f, 0x59, 0x3d, 0xab, 0xcd, 0x62, 0xb3, 0x57,
+		0x16, 0x39, 0xd6, 0x24, 0xe6, 0x51, 0x52, 0xab,
+		0x8f, 0x53, 0x0c, 0x35, 0x9f, 0x08, 0x61, 0xd8,
+		0x07, 0xca, 0x0d, 0xbf, 0x50, 0x0d, 0x6a, 0x61,
+		0x56, 0xa3, 0x8e, 0x08, 0x8a, 0x22, 0xb6, 0x5e,
+		0x52, 0xbc, 0x51, 0x4d, 0x16, 0xcc, 0xf8, 0x06,
+		0x81, 0x8c, 0xe9, 0x1a, 0xb7, 0x79, 0x37, 0x36,
+		0x5a, 0xf9, 0x0b, 0xbf, 0x74, 0xa3, 0x5b, 0xe6,
+		0xb4, 0x0b, 0x8e, 0xed, 0xf2, 0x78, 0x5e, 0x42,
+		0x87, 0x4d,
+	}
+	ctx := NewIETF(key, nonce)
+	ctx.Seek(1)
+	got := make([]byte, len(plaintext))
+	ctx.Encrypt(plaintext, got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("IETF Encrypt:\n got %x\nwant %x", got, want)
+	}
+	if c := ctx.GetCounter(); c != 3 {
+		t.Errorf("IETF GetCounter: got %d want 3", c)
+	}
+
+	// The nonce must not be disturbed by the counter in the parallel path,
+	// even close to the 32-bit counter wrap.
+	p := make([]byte, 1000*blockLen)
+	np := make([]byte, len(p))
+	ctx.Seek(0xffffffff - 1000)
+	ctx.Read(p)
+	ctx.UseParallel(false)
+	ctx.Seek(0xffffffff - 1000)
+	ctx.Read(np)
+	if !bytes.Equal(p, np) {
+		t.Errorf("IETF parallel vs non-parallel: p != np")
+	}
+	ctx.UseParallel(true)
+
+	// Key stream exhaustion occurs at the 32-bit counter wrap.
+	got = make([]byte, blockLen)
+	ctx.Seek(0xffffffff)
+	n, err := ctx.Read(got)
+	if err != io.EOF || n != blockLen {
+		t.Errorf("IETF Read() at EOF: got n=%d err=%v, want n=%d err=%v",
+			n, err, blockLen, io.EOF)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("IETF ChaCha20 did not panic for Read after EOF")
+			}
+		}()
+		ctx.Read(got[:1])
+	}()
+
+	// Chunk processing must stop at the 32-bit counter wrap too.
+	var offsets = []int{-2, -1, 0, 1, 2}
+	for k := 0; k < len(offsets); k++ {
+		bcOffset := blocksPerChunk*2 + offsets[k]
+		got = make([]byte, blockLen*(bcOffset+1))
+		ctx.Seek(1<<32 - uint64(bcOffset))
+		n, err = ctx.Read(got)
+		if err != io.EOF || n != blockLen*bcOffset {
+			t.Errorf("IETF chunking EOF: offset %d got n=%d err=%v, want n=%d err=%v",
+				offsets[k], n, err, blockLen*bcOffset, io.EOF)
+		}
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("IETF Seek did not panic past the 32-bit counter")
+			}
+		}()
+		ctx.Seek(1 << 32)
+	}()
+}
+
+func TestErrors(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+
+	if _, err := NewWithError(key[:31], iv); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("NewWithError short key: got %v want %v", err, ErrInvalidKeySize)
+	}
+	if _, err := NewWithError(key, iv[:7]); !errors.Is(err, ErrInvalidNonceSize) {
+		t.Errorf("NewWithError short iv: got %v want %v", err, ErrInvalidNonceSize)
+	}
+	if _, err := NewIETFWithError(key[:16], make([]byte, 12)); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("NewIETFWithError 16-byte key: got %v want %v", err, ErrInvalidKeySize)
+	}
+	if _, err := NewIETFWithError(key, iv); !errors.Is(err, ErrInvalidNonceSize) {
+		t.Errorf("NewIETFWithError 8-byte nonce: got %v want %v", err, ErrInvalidNonceSize)
+	}
+	if _, err := NewXWithError(key, make([]byte, 12)); !errors.Is(err, ErrInvalidNonceSize) {
+		t.Errorf("NewXWithError 12-byte nonce: got %v want %v", err, ErrInvalidNonceSize)
+	}
+	if _, err := HChaCha20WithError(key[:31], make([]byte, 16)); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("HChaCha20WithError short key: got %v want %v", err, ErrInvalidKeySize)
+	}
+	if _, err := HChaCha20WithError(key, make([]byte, 24)); !errors.Is(err, ErrInvalidNonceSize) {
+		t.Errorf("HChaCha20WithError 24-byte nonce: got %v want %v", err, ErrInvalidNonceSize)
+	}
+	if _, err := NewAEADWithError(key[:16]); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("NewAEADWithError 16-byte key: got %v want %v", err, ErrInvalidKeySize)
+	}
+	if _, err := NewXAEADWithError(nil); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("NewXAEADWithError nil key: got %v want %v", err, ErrInvalidKeySize)
+	}
+	if a, err := NewAEADWithError(key); a == nil || err != nil {
+		t.Errorf("NewAEADWithError: got %v, %v", a, err)
+	}
+	ctx, err := NewWithError(key, iv)
+	if err != nil || ctx == nil {
+		t.Fatalf("NewWithError: got %v, %v", ctx, err)
+	}
+
+	// A failed setup must leave the context unchanged.
+	want := make([]byte, blockLen)
+	ctx.Keystream(want)
+	ctx.Seek(0)
+	if err := ctx.SetRoundsErr(10); !errors.Is(err, ErrInvalidRounds) {
+		t.Errorf("SetRoundsErr(10): got %v want %v", err, ErrInvalidRounds)
+	}
+	if err := ctx.KeySetupErr(key[:24]); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("KeySetupErr 24-byte key: got %v want %v", err, ErrInvalidKeySize)
+	}
+	if err := ctx.IvSetupErr(make([]byte, 12)); !errors.Is(err, ErrInvalidNonceSize) {
+		t.Errorf("IvSetupErr 12-byte iv: got %v want %v", err, ErrInvalidNonceSize)
+	}
+	got := make([]byte, blockLen)
+	ctx.Keystream(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("context changed by failed setup")
+	}
+
+	// The panicking API panics with the same errors.
+	wantPanic := func(name string, target error, f func()) {
+		defer func() {
+			r := recover()
+			if err, ok := r.(error); !ok || !errors.Is(err, target) {
+				t.Errorf("%s: panicked with %v want %v", name, r, target)
+			}
+		}()
+		f()
+	}
+	wantPanic("New", ErrInvalidKeySize, func() { New(key[:8], iv) })
+	wantPanic("HChaCha20", ErrInvalidNonceSize, func() { HChaCha20(key, iv) })
+	wantPanic("NewAEAD", ErrInvalidKeySize, func() { NewAEAD(key[:8]) })
+	wantPanic("NewXAEAD", ErrInvalidKeySize, func() { NewXAEAD(key[:8]) })
+	wantPanic("IvSetup", ErrInvalidNonceSize, func() { ctx.IvSetup(nil) })
+	wantPanic("SetRounds", ErrInvalidRounds, func() { ctx.SetRounds(0) })
+	ctx.Seek(0xffffffffffffffff)
+	ctx.Read(got)
+	wantPanic("Read", ErrKeystreamExhausted, func() { ctx.Read(got) })
+}
+
+func TestExhaustionPolicy(t *testing.T) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	got := make([]byte, 3*blockLen)
+
+	// ExhaustError: Encrypt, Read and XORKeyStream report the exhaustion
+	// instead of panicking.
+	ctx := NewIETF(key, nonce)
+	ctx.SetExhaustionPolicy(ExhaustError, nil)
+	ctx.Seek(0xffffffff)
+	if n, err := ctx.Read(got); n != blockLen || err != io.EOF {
+		t.Errorf("ExhaustError Read at EOF: got %d, %v want %d, %v", n, err, blockLen, io.EOF)
+	}
+	if n, err := ctx.Read(got); n != 0 || !errors.Is(err, ErrKeystreamExhausted) {
+		t.Errorf("ExhaustError Read after EOF: got %d, %v want 0, %v", n, err, ErrKeystreamExhausted)
+	}
+	if n, err := ctx.Encrypt(got, got); n != 0 || !errors.Is(err, ErrKeystreamExhausted) {
+		t.Errorf("ExhaustError Encrypt after EOF: got %d, %v want 0, %v", n, err, ErrKeystreamExhausted)
+	}
+	ctx.Seek(0xffffffff)
+	for i := 0; i < len(got); i++ {
+		got[i] = 0xaa
+	}
+	ctx.XORKeyStream(got, got)
+	if !errors.Is(ctx.Err(), ErrKeystreamExhausted) {
+		t.Errorf("ExhaustError XORKeyStream: Err() got %v want %v", ctx.Err(), ErrKeystreamExhausted)
+	}
+	if !bytes.Equal(got[blockLen:], make([]byte, 2*blockLen)) {
+		t.Errorf("ExhaustError XORKeyStream left unencrypted bytes in dst")
+	}
+	ctx.Seek(0)
+	if ctx.Err() != nil {
+		t.Errorf("Seek did not clear Err: %v", ctx.Err())
+	}
+
+	// ExhaustRekey: the key stream continues with the new key and iv at
+	// block 0, in both the serial and the parallel paths.
+	key2 := make([]byte, 32)
+	nonce2 := make([]byte, 12)
+	crand.Read(key2)
+	crand.Read(nonce2)
+	rekeys := 0
+	rekey := func() ([]byte, []byte, error) {
+		rekeys++
+		return key2, nonce2, nil
+	}
+	sizes := []int{3 * blockLen, 1000 * blockLen}
+	for k := 0; k < len(sizes); k++ {
+		rekeys = 0
+		got = make([]byte, sizes[k])
+		ctx = NewIETF(key, nonce)
+		ctx.SetExhaustionPolicy(ExhaustRekey, rekey)
+		ctx.Seek(0xffffffff)
+		if n, err := ctx.Read(got); n != len(got) || err != nil || rekeys != 1 {
+			t.Errorf("ExhaustRekey Read size %d: got %d, %v, %d rekeys want %d, nil, 1",
+				sizes[k], n, err, rekeys, len(got))
+		}
+		want := make([]byte, sizes[k])
+		old := NewIETF(key, nonce)
+		old.Seek(0xffffffff)
+		old.Read(want[:blockLen])
+		NewIETF(key2, nonce2).Read(want[blockLen:])
+		if !bytes.Equal(got, want) {
+			t.Errorf("ExhaustRekey Read size %d: wrong key stream", sizes[k])
+		}
+	}
+
+	// A failing RekeyFunc is reported like ExhaustError.
+	errRekey := errors.New("no more keys")
+	ctx = NewIETF(key, nonce)
+	ctx.SetExhaustionPolicy(ExhaustRekey, func() ([]byte, []byte, error) {
+		return nil, nil, errRekey
+	})
+	ctx.Seek(0xffffffff)
+	got = make([]byte, 2*blockLen)
+	if n, err := ctx.Read(got); n != blockLen || !errors.Is(err, errRekey) {
+		t.Errorf("failing RekeyFunc: got %d, %v want %d, %v", n, err, blockLen, errRekey)
+	}
+	ctx.Seek(0xffffffff)
+	ctx.Keystream(got)
+	if !errors.Is(ctx.Err(), errRekey) {
+		t.Errorf("failing RekeyFunc Keystream: Err() got %v want %v", ctx.Err(), errRekey)
+	}
+}
+
+// keystreamBlocksGeneric puts multiBlocks key stream blocks with counters
+// input[12], input[12]+1, ... into out, one block at a time.  It is the
+// reference the SIMD cores are tested against.
+func keystreamBlocksGeneric(input *[16]uint32, rounds int, out *multiBuf) {
+	s := *input
+	for i := 0; i < multiBlocks; i++ {
+		salsa20_wordtobyte(s[:], rounds, out[i*blockLen:])
+		s[12]++
+	}
+}
+
+func TestKeystreamBlocks(t *testing.T) {
+	// The multi-block cores must match the scalar core for every number of
+	// rounds.
+	rounds := []int{8, 12, 20}
+	var seed [64]byte
+	for trial := 0; trial < 30; trial++ {
+		var input [16]uint32
+		crand.Read(seed[:])
+		for i := 0; i < 16; i++ {
+			input[i] = uint32(seed[4*i]) | uint32(seed[4*i+1])<<8 |
+				uint32(seed[4*i+2])<<16 | uint32(seed[4*i+3])<<24
+		}
+		r := rounds[trial%len(rounds)]
+		var got, want multiBuf
+		keystreamBlocks(&input, r, &got)
+		keystreamBlocksGeneric(&input, r, &want)
+		if !bytes.Equal(got[:multiBlocks*blockLen], want[:multiBlocks*blockLen]) {
+			t.Fatalf("keystreamBlocks rounds %d:\n got %x\nwant %x", r, got, want)
+		}
+		var got4 multiBuf
+		keystreamBlocks4Go(&input, r, &got4)
+		s := input
+		for i := 0; i < 4; i++ {
+			salsa20_wordtobyte(s[:], r, want[i*blockLen:])
+			s[12]++
+		}
+		if !bytes.Equal(got4[:4*blockLen], want[:4*blockLen]) {
+			t.Fatalf("keystreamBlocks4Go rounds %d:\n got %x\nwant %x", r, got4, want)
+		}
+	}
+
+	// Encrypt must carry the block counter correctly into its upper word
+	// when multi-block groups stop short of the low word's wrap.
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	crand.Read(key)
+	crand.Read(iv)
+	const base = 1<<32 - 5
+	got := make([]byte, 40*blockLen)
+	ctx := NewSmallMemory(key, iv)
+	ctx.Seek(base)
+	ctx.Keystream(got)
+	want := make([]byte, len(got))
+	for i := 0; i < 40; i++ {
+		ctx.Seek(base + uint64(i))
+		ctx.Keystream(want[i*blockLen : (i+1)*blockLen])
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("multi-block Keystream across counter word wrap differs")
+	}
+}
+
+func TestEncryptContext(t *testing.T) {
+	m := make([]byte, 32<<20)
+	crand.Read(m)
+	want := make([]byte, len(m))
+	NewSmallMemory(key, iv).Encrypt(m, want)
+
+	// A cancelled context processes nothing.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	x := New(key, iv)
+	c := make([]byte, len(m))
+	if n, err := x.EncryptContext(cancelled, m, c); n != 0 || err != context.Canceled {
+		t.Errorf("EncryptContext with cancelled context = %d, %v", n, err)
+	}
+	b := []byte{1, 2, 3}
+	if n, err := x.ReadContext(cancelled, b); n != 0 || err != context.Canceled ||
+		!bytes.Equal(b, []byte{0, 0, 0}) {
+		t.Errorf("ReadContext with cancelled context = %d, %v, %v", n, err, b)
+	}
+
+	// Cancelled part way, EncryptContext has processed exactly n bytes
+	// and x resumes after them, in every processing mode.
+	p := NewPool(0)
+	defer p.Close()
+	modes := []string{"goroutines", "pool", "serial", "rekey"}
+	for i := 0; i < len(modes); i++ {
+		x := New(key, iv)
+		rekeys := 0
+		switch modes[i] {
+		case "pool":
+			x.UsePool(p)
+		case "serial":
+			x.UseParallel(false)
+		case "rekey":
+			// Cancelling must not rekey, which would also reset the counter.
+			x.SetExhaustionPolicy(ExhaustRekey, func() ([]byte, []byte, error) {
+				rekeys++
+				return key, iv, nil
+			})
+		}
+		x.Encrypt(m[:10], c[:10]) // unaligned start
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(2*time.Millisecond, cancel)
+		n, err := x.EncryptContext(ctx, m[10:], c[10:])
+		cancel()
+		n += 10
+		if (n < len(m)) != (err == context.Canceled) || err != nil && err != context.Canceled {
+			t.Errorf("%s: EncryptContext = %d, %v of %d bytes", modes[i], n, err, len(m))
+		}
+		if rekeys != 0 {
+			t.Errorf("%s: cancelled EncryptContext rekeyed %d times", modes[i], rekeys)
+		}
+		if !bytes.Equal(c[:n], want[:n]) {
+			t.Fatalf("%s: EncryptContext's first %d bytes are wrong", modes[i], n)
+		}
+		if k, err := x.EncryptContext(context.Background(), m[n:], c[n:]); k != len(m)-n || err != nil {
+			t.Errorf("%s: resumed EncryptContext = %d, %v", modes[i], k, err)
+		}
+		if !bytes.Equal(c, want) {
+			t.Errorf("%s: resumed EncryptContext differs from Encrypt (stopped at %d)", modes[i], n)
+		}
+		t.Logf("%s: cancelled after %d of %d bytes", modes[i], n, len(m))
+	}
+}
+
+func TestXORKeyStreamAt(t *testing.T) {
+	ref := make([]byte, 1<<20)
+	NewSmallMemory(key, iv).Read(ref)
+	src := make([]byte, len(ref))
+	crand.Read(src)
+
+	x := New(key, iv)
+	x.TuneParallel(25, 0) // parallel process from 3,200 bytes
+	x.Read(make([]byte, 100))
+
+	// Many goroutines on one Ctx, at unaligned offsets and lengths.
+	type span struct{ off, n int }
+	spans := []span{{0, 1}, {1, 63}, {63, 2}, {64, 64}, {100, 7000},
+		{4095, 300_001}, {500_000, 500_000}, {1<<20 - 5, 5}, {12345, 0}}
+	var wg sync.WaitGroup
+	for i := 0; i < len(spans); i++ {
+		wg.Add(1)
+		go func(s span) {
+			defer wg.Done()
+			dst := make([]byte, s.n)
+			x.XORKeyStreamAt(dst, src[s.off:s.off+s.n], uint64(s.off))
+			for j := 0; j < s.n; j++ {
+				if dst[j] != src[s.off+j]^ref[s.off+j] {
+					t.Errorf("XORKeyStreamAt%v: wrong output at byte %d", s, j)
+					return
+				}
+			}
+		}(spans[i])
+	}
+	wg.Wait()
+	if tell := x.TellByte(); tell != 100 {
+		t.Errorf("XORKeyStreamAt moved x from 100 to %d", tell)
+	}
+
+	// The end of an IETF key stream.
+	ietf := NewIETF(key, make([]byte, 12))
+	ietf.Seek(0xffffffff)
+	last := make([]byte, blockLen)
+	ietf.Read(last)
+	got := make([]byte, 10)
+	ietf.XORKeyStreamAt(got, got, 1<<38-10)
+	if !bytes.Equal(got, last[54:]) {
+		t.Errorf("IETF XORKeyStreamAt of the last 10 bytes = %x, want %x", got, last[54:])
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrKeystreamExhausted {
+				t.Errorf("IETF XORKeyStreamAt beyond the end: recovered %v", r)
+			}
+		}()
+		ietf.XORKeyStreamAt(got, got, 1<<38-9)
+	}()
+}
@@ -0,0 +1,178 @@
+// chacha8rand.go - public domain ChaCha8Rand generator.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// ChaCha8Rand reproduces, bit for bit, the output of math/rand/v2's ChaCha8
+// and of the Go runtime's generator, which follow the ChaCha8Rand
+// specification at <https://c2sp.org/chacha8rand>.  Each iteration keys
+// ChaCha8 with a 32-byte seed and a zero nonce, computes blocks 0-15,
+// subtracts the input words that carry no entropy (the constants and the
+// block counter), interleaves each 4 blocks' 32-bit words, and returns all
+// but the last 32 bytes as 124 uint64 values.  The last 32 bytes are the
+// next iteration's seed.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	chacha8Blocks = 16                           // ChaCha8 blocks per iteration
+	chacha8Values = chacha8Blocks * blockLen / 8 // uint64s per iteration
+	chacha8Output = chacha8Values - 4            // uint64s returned per iteration
+)
+
+// ChaCha8Rand is a pseudorandom generator whose Uint64 and Read methods
+// return exactly what math/rand/v2's ChaCha8 does for the same seed, and
+// whose MarshalBinary and UnmarshalBinary use the same state format, so
+// that state can be moved between the two.  It implements math/rand/v2's
+// Source interface and io.Reader.
+//
+// A ChaCha8Rand is not safe for concurrent use.  Use NewChaCha8Rand to
+// allocate one; the zero ChaCha8Rand is usable only as the receiver of
+// Seed or UnmarshalBinary.
+type ChaCha8Rand struct {
+	x       *Ctx
+	buf     [chacha8Values]uint64 // this iteration's values
+	i       int                   // buf[i:chacha8Output] are unused
+	readBuf [8]byte               // Read's unused bytes are the last readLen
+	readLen int
+}
+
+// NewChaCha8Rand returns a ChaCha8Rand seeded with seed, like
+// math/rand/v2's NewChaCha8(seed).
+func NewChaCha8Rand(seed [32]byte) *ChaCha8Rand {
+	r := &ChaCha8Rand{}
+	r.Seed(seed)
+	return r
+}
+
+// Seed resets r to behave the same way as NewChaCha8Rand(seed).
+func (r *ChaCha8Rand) Seed(seed [32]byte) {
+	r.iterate(seed[:])
+	r.readLen = 0
+	r.readBuf = [8]byte{}
+}
+
+// iterate fills r.buf with the values of the iteration seeded by seed.
+func (r *ChaCha8Rand) iterate(seed []byte) {
+	if r.x == nil {
+		r.x = NewSmallMemory(seed, make([]byte, 8))
+		r.x.SetRounds(8)
+	} else {
+		r.x.KeySetup(seed)
+		r.x.Seek(0)
+	}
+	var ks [chacha8Blocks * blockLen]byte
+	r.x.Keystream(ks[:])
+	// Word w of block b of group g of 4 blocks is 32-bit value
+	// g*64 + w*4 + b%4 of the iteration.
+	f := 0
+	for g := 0; g < chacha8Blocks; g += 4 {
+		for w := 0; w < 16; w++ {
+			for b := g; b < g+4; b++ {
+				v := binary.LittleEndian.Uint32(ks[b*blockLen+4*w:])
+				if w < 4 {
+					v -= r.x.input[w] // constant
+				} else if w == 12 {
+					v -= uint32(b) // block counter
+				}
+				if f%2 == 0 {
+					r.buf[f/2] = uint64(v)
+				} else {
+					r.buf[f/2] |= uint64(v) << 32
+				}
+				f++
+			}
+		}
+	}
+	clear(ks[:])
+	r.i = 0
+}
+
+// Uint64 returns a uniformly distributed random uint64 value.
+func (r *ChaCha8Rand) Uint64() uint64 {
+	if r.i == chacha8Output {
+		var seed [32]byte
+		for i := 0; i < 4; i++ {
+			binary.LittleEndian.PutUint64(seed[8*i:], r.buf[chacha8Output+i])
+		}
+		r.iterate(seed[:])
+	}
+	v := r.buf[r.i]
+	r.i++
+	return v
+}
+
+// Read fills p with random bytes, 8 bytes per Uint64 value in little-endian
+// order, keeping the unused bytes of a last partial value for the next
+// Read.  It always returns len(p), nil.
+func (r *ChaCha8Rand) Read(p []byte) (n int, err error) {
+	if r.readLen > 0 {
+		n = copy(p, r.readBuf[len(r.readBuf)-r.readLen:])
+		r.readLen -= n
+		p = p[n:]
+	}
+	for len(p) >= 8 {
+		binary.LittleEndian.PutUint64(p, r.Uint64())
+		p = p[8:]
+		n += 8
+	}
+	if len(p) > 0 {
+		binary.LittleEndian.PutUint64(r.readBuf[:], r.Uint64())
+		n += copy(p, r.readBuf[:])
+		r.readLen = len(r.readBuf) - len(p)
+	}
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler in math/rand/v2's
+// ChaCha8 format: "chacha8:", the big-endian uint64 count of values used
+// in the current iteration and its seed as 4 little-endian uint64s,
+// preceded by "readbuf:", a length byte and the bytes if Read left any
+// unused.  The state includes the seed, so protect it as you would the
+// seed.
+func (r *ChaCha8Rand) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 64)
+	if r.readLen > 0 {
+		b = append(b, "readbuf:"...)
+		b = append(b, byte(r.readLen))
+		b = append(b, r.readBuf[len(r.readBuf)-r.readLen:]...)
+	}
+	b = append(b, "chacha8:"...)
+	b = binary.BigEndian.AppendUint64(b, uint64(r.i))
+	for i := 4; i < 12; i++ { // the key words are the seed's bytes in order
+		b = binary.LittleEndian.AppendUint32(b, r.x.input[i])
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  It accepts what
+// MarshalBinary, or math/rand/v2's ChaCha8 MarshalBinary, returns.
+func (r *ChaCha8Rand) UnmarshalBinary(data []byte) error {
+	var readBuf []byte
+	if len(data) > 8 && string(data[:8]) == "readbuf:" {
+		n := int(data[8])
+		if n > len(r.readBuf) || len(data) < 9+n {
+			return errors.New("chacha20.ChaCha8Rand.UnmarshalBinary: invalid Read buffer encoding")
+		}
+		readBuf = data[9 : 9+n]
+		data = data[9+n:]
+	}
+	if len(data) != 48 || string(data[:8]) != "chacha8:" {
+		return errors.New("chacha20.ChaCha8Rand.UnmarshalBinary: invalid state")
+	}
+	used := binary.BigEndian.Uint64(data[8:])
+	if used > chacha8Output {
+		return errors.New("chacha20.ChaCha8Rand.UnmarshalBinary: invalid state")
+	}
+	r.iterate(data[16:48])
+	r.i = int(used)
+	r.readBuf = [8]byte{}
+	r.readLen = copy(r.readBuf[len(r.readBuf)-len(readBuf):], readBuf)
+	return nil
+}
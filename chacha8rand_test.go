@@ -0,0 +1,92 @@
+// chacha8rand_test.go - test ChaCha8Rand against math/rand/v2's ChaCha8.
+// math/rand/v2.ChaCha8.Read and MarshalBinary need Go 1.23.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+//go:build go1.23
+
+package chacha20
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"math/rand/v2"
+	"testing"
+)
+
+var _ rand.Source = &ChaCha8Rand{}
+
+func TestChaCha8Rand(t *testing.T) {
+	seeds := make([][32]byte, 3)
+	copy(seeds[1][:], "chacha8rand key for testing 1234")
+	crand.Read(seeds[2][:])
+	for s := 0; s < len(seeds); s++ {
+		want := rand.NewChaCha8(seeds[s])
+		got := NewChaCha8Rand(seeds[s])
+		for i := 0; i < 10_000; i++ { // 80 iterations
+			if a, b := got.Uint64(), want.Uint64(); a != b {
+				t.Fatalf("seed %d: Uint64 number %d = %#x, want %#x", s, i, a, b)
+			}
+		}
+
+		// Read, including partial values, and states in both directions.
+		lengths := []int{3, 8, 13, 1000, 5, 0, 2000}
+		for i := 0; i < len(lengths); i++ {
+			a, b := make([]byte, lengths[i]), make([]byte, lengths[i])
+			got.Read(a)
+			want.Read(b)
+			if !bytes.Equal(a, b) {
+				t.Fatalf("seed %d: Read number %d differs", s, i)
+			}
+			state, _ := got.MarshalBinary()
+			wantState, _ := want.MarshalBinary()
+			if !bytes.Equal(state, wantState) {
+				t.Fatalf("seed %d: MarshalBinary after Read %d = %q, want %q", s, i, state, wantState)
+			}
+			if i%2 == 0 {
+				got = &ChaCha8Rand{}
+				if err := got.UnmarshalBinary(wantState); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := want.UnmarshalBinary(state); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 500; i++ {
+			if a, b := got.Uint64(), want.Uint64(); a != b {
+				t.Fatalf("seed %d: Uint64 number %d after UnmarshalBinary differs", s, i)
+			}
+		}
+	}
+
+	// The end of an iteration, where the next seed is pending.
+	r := NewChaCha8Rand(seeds[1])
+	for i := 0; i < chacha8Output; i++ {
+		r.Uint64()
+	}
+	state, _ := r.MarshalBinary()
+	want := rand.NewChaCha8([32]byte{})
+	if err := want.UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := r.Uint64(), want.Uint64(); a != b {
+		t.Errorf("Uint64 after an iteration's last value = %#x, want %#x", a, b)
+	}
+
+	bad := [][]byte{nil, []byte("chacha8:"), append([]byte("readbuf:\x09"), state...)}
+	state[15] = chacha8Output + 1 // values used
+	bad = append(bad, state)
+	for i := 0; i < len(bad); i++ {
+		if err := r.UnmarshalBinary(bad[i]); err == nil {
+			t.Errorf("UnmarshalBinary of invalid state %d succeeded", i)
+		}
+	}
+}
+
+func BenchmarkChaCha8Rand_Uint64(b *testing.B) {
+	r := NewChaCha8Rand([32]byte{})
+	b.SetBytes(8)
+	for i := 0; i < b.N; i++ {
+		r.Uint64()
+	}
+}
@@ -0,0 +1,355 @@
+// main.go - public domain chacha20bench, a ChaCha20 benchmarking command.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// chacha20bench sweeps rounds, blocks per chunk, goroutine limits, message
+// sizes and the serial vs parallel Encrypt path, and reports speed and
+// memory allocation for each combination.  It replaces
+// tuning/chacha20TuneParallel.go.
+//
+// Usage:
+//
+//	chacha20bench [flags]
+//
+// Flags:
+//
+//	-rounds list      rounds to measure (default "8,12,20")
+//	-bpc list         blocks per chunk to measure (default "25,50,100,200,400")
+//	-goroutines list  goroutine limits to measure (default GOMAXPROCS and 300)
+//	-sizes list       message sizes; k, M and G suffixes are powers of 1024
+//	                  (default "64k,1M,16M")
+//	-modes list       serial and/or parallel (default "serial,parallel")
+//	-time duration    time spent measuring each combination (default 200ms)
+//	-format name      table, csv or json (default table)
+//	-o file           write the chosen parameters to file as a
+//	                  chacha20.TuningProfile, for LoadTuningProfile
+//
+// The chosen parameters are the fastest parallel blocks per chunk and
+// goroutine limit for the largest size and the last rounds listed,
+// preferring smaller values within 3% of the fastest.  MinParallelSize is
+// the smallest size from which they beat the serial path at that size and
+// every larger size measured, and if there is none SerialCutoff is set to
+// GOMAXPROCS.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charltoncr/chacha20"
+)
+
+// result is one measured combination.  BlocksPerChunk and MaxGoroutines
+// are zero for the serial path.
+type result struct {
+	Mode           string  `json:"mode"`
+	Rounds         int     `json:"rounds"`
+	BlocksPerChunk int     `json:"blocks_per_chunk"`
+	MaxGoroutines  int     `json:"max_goroutines"`
+	Size           int     `json:"size"`
+	GBPerSec       float64 `json:"gb_per_sec"`
+	NsPerBlock     float64 `json:"ns_per_block"`
+	BytesPerOp     uint64  `json:"bytes_allocated_per_op"`
+}
+
+// tolerance is how much slower than the fastest parallel parameters the
+// chosen ones may be, in return for smaller values.  AutoTune uses the same.
+const tolerance = 0.03
+
+func main() {
+	roundsFlag := flag.String("rounds", "8,12,20", "comma-separated `list` of rounds")
+	bpcFlag := flag.String("bpc", "25,50,100,200,400", "comma-separated `list` of blocks per chunk")
+	goroutinesFlag := flag.String("goroutines", fmt.Sprintf("%d,300", runtime.GOMAXPROCS(0)),
+		"comma-separated `list` of goroutine limits")
+	sizesFlag := flag.String("sizes", "64k,1M,16M",
+		"comma-separated `list` of message sizes (k, M and G suffixes are powers of 1024)")
+	modesFlag := flag.String("modes", "serial,parallel", "comma-separated `list` of serial and/or parallel")
+	measure := flag.Duration("time", 200*time.Millisecond, "time spent measuring each combination")
+	format := flag.String("format", "table", "output `format`: table, csv or json")
+	out := flag.String("o", "", "write the chosen parameters to `file` as a tuning profile")
+	flag.Parse()
+
+	rounds, err := parseList(*roundsFlag, strconv.Atoi)
+	check(err)
+	bpcs, err := parseList(*bpcFlag, strconv.Atoi)
+	check(err)
+	goroutines, err := parseList(*goroutinesFlag, strconv.Atoi)
+	check(err)
+	sizes, err := parseList(*sizesFlag, parseSize)
+	check(err)
+	modes, err := parseList(*modesFlag, parseMode)
+	check(err)
+	if *format != "table" && *format != "csv" && *format != "json" {
+		check(fmt.Errorf("unknown format %q", *format))
+	}
+	if *out != "" && !contains(modes, "parallel") {
+		check(fmt.Errorf("-o requires the parallel mode"))
+	}
+	sort.Ints(sizes)
+
+	var results []result
+	for i := 0; i < len(modes); i++ {
+		for j := 0; j < len(rounds); j++ {
+			for k := 0; k < len(sizes); k++ {
+				if modes[i] == "serial" {
+					results = append(results, run("serial", rounds[j], 0, 0, sizes[k], *measure))
+					continue
+				}
+				for b := 0; b < len(bpcs); b++ {
+					for g := 0; g < len(goroutines); g++ {
+						results = append(results, run("parallel", rounds[j],
+							bpcs[b], goroutines[g], sizes[k], *measure))
+					}
+				}
+			}
+		}
+	}
+
+	switch *format {
+	case "table":
+		writeTable(os.Stdout, results)
+	case "csv":
+		check(writeCSV(os.Stdout, results))
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		check(enc.Encode(results))
+	}
+
+	if *out != "" {
+		p := choose(results, rounds[len(rounds)-1], sizes)
+		check(chacha20.SaveTuningProfile(*out, p))
+		fmt.Fprintf(os.Stderr, "chacha20bench: wrote %s: blocks per chunk %d, max goroutines %d, "+
+			"min parallel size %d, serial cutoff %d\n", *out, p.BlocksPerChunk,
+			p.MaxGoroutines, p.MinParallelSize, p.SerialCutoff)
+		if p.Fingerprint.CPUModel == "" {
+			fmt.Fprintf(os.Stderr, "chacha20bench: warning: CPU model unknown; "+
+				"LoadTuningProfile will report a fingerprint mismatch\n")
+		}
+	}
+}
+
+// run measures Encrypt on a size-byte message for about d.
+func run(mode string, rounds, bpc, goroutines, size int, d time.Duration) result {
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	crand.Read(key)
+	crand.Read(iv)
+	x := chacha20.New(key, iv)
+	x.SetRounds(rounds)
+	if mode == "serial" {
+		x.UseParallel(false)
+	} else {
+		x.UseParallel(true)
+		x.TuneParallel(bpc, goroutines)
+	}
+	m := make([]byte, size)
+	x.Encrypt(m, m) // warm up
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	calls := 0
+	start := time.Now()
+	elapsed := time.Duration(0)
+	for calls == 0 || elapsed < d {
+		x.Encrypt(m, m)
+		calls++
+		elapsed = time.Since(start)
+	}
+	runtime.ReadMemStats(&after)
+
+	bytes := float64(calls) * float64(size)
+	return result{
+		Mode:           mode,
+		Rounds:         rounds,
+		BlocksPerChunk: bpc,
+		MaxGoroutines:  goroutines,
+		Size:           size,
+		GBPerSec:       bytes / elapsed.Seconds() / 1e9,
+		NsPerBlock:     float64(elapsed.Nanoseconds()) / (bytes / 64),
+		BytesPerOp:     (after.TotalAlloc - before.TotalAlloc) / uint64(calls),
+	}
+}
+
+// choose returns the tuning profile for the results with the given rounds.
+func choose(results []result, rounds int, sizes []int) chacha20.TuningProfile {
+	largest := sizes[len(sizes)-1]
+	var best *result
+	fastest := 0.0
+	for i := 0; i < len(results); i++ {
+		r := &results[i]
+		if r.Mode == "parallel" && r.Rounds == rounds && r.Size == largest {
+			fastest = max(fastest, r.GBPerSec)
+		}
+	}
+	for i := 0; i < len(results); i++ {
+		r := &results[i]
+		if r.Mode != "parallel" || r.Rounds != rounds || r.Size != largest ||
+			r.GBPerSec < fastest*(1-tolerance) {
+			continue
+		}
+		if best == nil || r.BlocksPerChunk < best.BlocksPerChunk ||
+			r.BlocksPerChunk == best.BlocksPerChunk && r.MaxGoroutines < best.MaxGoroutines {
+			best = r
+		}
+	}
+
+	p := chacha20.NewTuningProfile(chacha20.TuneParams{
+		BlocksPerChunk: best.BlocksPerChunk,
+		MaxGoroutines:  best.MaxGoroutines,
+	})
+	serial := make(map[int]float64)
+	for i := 0; i < len(results); i++ {
+		r := results[i]
+		if r.Mode == "serial" && r.Rounds == rounds {
+			serial[r.Size] = r.GBPerSec
+		}
+	}
+	if len(serial) == 0 {
+		return p // no serial measurements to compare with
+	}
+	// parallelWins reports whether best's parameters beat the serial path
+	// on size-byte messages.
+	parallelWins := func(size int) bool {
+		for j := 0; j < len(results); j++ {
+			r := results[j]
+			if r.Mode == "parallel" && r.Rounds == rounds && r.Size == size &&
+				r.BlocksPerChunk == best.BlocksPerChunk &&
+				r.MaxGoroutines == best.MaxGoroutines {
+				return r.GBPerSec > serial[size]
+			}
+		}
+		return false
+	}
+	from := -1 // parallel wins at sizes[from:]
+	for i := len(sizes) - 1; i >= 0 && parallelWins(sizes[i]); i-- {
+		from = i
+	}
+	if from < 0 {
+		p.SerialCutoff = runtime.GOMAXPROCS(0)
+		return p
+	}
+	p.MinParallelSize = sizes[from]
+	return p
+}
+
+func writeTable(w io.Writer, results []result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Mode\tRounds\tBlocks/chunk\tGoroutines\tSize\tGB/s\tns/block\tB/op\t\n")
+	fmt.Fprintf(tw, "----\t------\t------------\t----------\t----\t----\t--------\t----\t\n")
+	for i := 0; i < len(results); i++ {
+		r := results[i]
+		bpc, g := "-", "-"
+		if r.Mode == "parallel" {
+			bpc, g = strconv.Itoa(r.BlocksPerChunk), strconv.Itoa(r.MaxGoroutines)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%.2f\t%.1f\t%d\t\n", r.Mode, r.Rounds,
+			bpc, g, formatSize(r.Size), r.GBPerSec, r.NsPerBlock, r.BytesPerOp)
+	}
+	tw.Flush()
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"mode", "rounds", "blocks_per_chunk", "max_goroutines",
+		"size", "gb_per_sec", "ns_per_block", "bytes_allocated_per_op"})
+	for i := 0; i < len(results); i++ {
+		r := results[i]
+		cw.Write([]string{r.Mode, strconv.Itoa(r.Rounds), strconv.Itoa(r.BlocksPerChunk),
+			strconv.Itoa(r.MaxGoroutines), strconv.Itoa(r.Size),
+			strconv.FormatFloat(r.GBPerSec, 'f', 3, 64),
+			strconv.FormatFloat(r.NsPerBlock, 'f', 2, 64),
+			strconv.FormatUint(r.BytesPerOp, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseList parses a comma-separated list with parse.
+func parseList[T any](s string, parse func(string) (T, error)) ([]T, error) {
+	fields := strings.Split(s, ",")
+	list := make([]T, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		v, err := parse(strings.TrimSpace(fields[i]))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// parseSize parses a size with an optional k, M or G suffix.
+func parseSize(s string) (int, error) {
+	mult := 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+func parseMode(s string) (string, error) {
+	if s != "serial" && s != "parallel" {
+		return "", fmt.Errorf("unknown mode %q", s)
+	}
+	return s, nil
+}
+
+// formatSize formats n bytes with the largest k, M or G suffix that
+// divides it.
+func formatSize(n int) string {
+	switch {
+	case n%(1<<30) == 0:
+		return strconv.Itoa(n>>30) + "G"
+	case n%(1<<20) == 0:
+		return strconv.Itoa(n>>20) + "M"
+	case n%(1<<10) == 0:
+		return strconv.Itoa(n>>10) + "k"
+	}
+	return strconv.Itoa(n)
+}
+
+func contains(list []string, s string) bool {
+	for i := 0; i < len(list); i++ {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chacha20bench:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+// drbg.go - public domain fast-key-erasure random number generator.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// DRBG follows D. J. Bernstein's fast-key-erasure construction
+// <https://blog.cr.yp.to/20170723-random.html>: each refill of its buffer
+// generates key stream, immediately replaces the key with the first 32
+// bytes of it, and hands out the rest, erasing each byte as it goes.  A
+// later memory disclosure then reveals neither past output nor the keys
+// that produced it.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"sync"
+)
+
+// drbgBufLen is the default DRBG buffer length.
+const drbgBufLen = 1024
+
+// DRBG is a deterministic random bit generator with forward secrecy.  It
+// implements io.Reader.  A DRBG is safe for concurrent use.
+type DRBG struct {
+	mu  sync.Mutex
+	x   *Ctx   // keyed with the current key, at block 0
+	buf []byte // buf[n:] is unused output
+	n   int
+}
+
+// NewDRBG returns a DRBG seeded with seed, which must be 32 bytes long, or
+// nil to seed from crypto/rand.  NewDRBG panics with ErrInvalidKeySize if
+// seed has another length.  NewDRBG keeps no reference to seed, which the
+// caller should erase.  bufSize is the number of bytes of key stream
+// generated per refill, rounded up to a multiple of 64 bytes; if bufSize <=
+// 0 it is 1024.  A larger buffer means fewer key changes per byte, but
+// more unused output in memory between reads.  Reads at least as long as
+// the buffer bypass it.  A DRBG never uses parallel processing, which
+// would copy its key into each chunk's work where it couldn't be erased.
+func NewDRBG(seed []byte, bufSize int) *DRBG {
+	var key [32]byte
+	if seed == nil {
+		crand.Read(key[:])
+		seed = key[:]
+	}
+	if len(seed) != 32 {
+		panic(ErrInvalidKeySize)
+	}
+	if bufSize <= 0 {
+		bufSize = drbgBufLen
+	}
+	bufSize = (bufSize + blockLen - 1) / blockLen * blockLen
+	d := &DRBG{
+		x:   NewSmallMemory(seed, make([]byte, 8)), // d.x is the only key copy
+		buf: make([]byte, bufSize),
+	}
+	d.n = len(d.buf)
+	clear(key[:])
+	return d
+}
+
+// Read fills p with random bytes.  It always returns len(p), nil.
+func (d *DRBG) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := copy(p, d.buf[d.n:])
+	clear(d.buf[d.n : d.n+n])
+	d.n += n
+	for n < len(p) {
+		if len(p)-n >= len(d.buf) {
+			// Key stream bytes 32 on go straight into p.
+			var key [32]byte
+			d.x.Keystream(key[:])
+			d.x.Keystream(p[n:])
+			d.rekey(key[:])
+			return len(p), nil
+		}
+		d.refill()
+		k := copy(p[n:], d.buf[d.n:])
+		clear(d.buf[d.n : d.n+k])
+		d.n += k
+		n += k
+	}
+	return n, nil
+}
+
+// refill fills d.buf with key stream and replaces d's key with its first
+// 32 bytes.
+func (d *DRBG) refill() {
+	d.x.Keystream(d.buf)
+	d.rekey(d.buf[:32])
+	d.n = 32
+}
+
+// rekey makes key, which it then erases, d's key, starting its key stream
+// anew.
+func (d *DRBG) rekey(key []byte) {
+	d.x.KeySetup(key)
+	d.x.Seek(0)
+	clear(d.x.output[:]) // may hold the last key stream block
+	clear(key)
+}
+
+// Reseed mixes entropy into d's key: the new key is the SHA-256 hash of 32
+// fresh bytes of d's key stream followed by entropy.  Any buffered output
+// is discarded.  Reseed adds to d's security, never reduces it, whatever
+// entropy is.
+func (d *DRBG) Reseed(entropy []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.buf)
+	d.n = len(d.buf)
+	var key [32]byte
+	d.x.Keystream(key[:])
+	h := sha256.New()
+	h.Write(key[:])
+	h.Write(entropy)
+	h.Sum(key[:0])
+	d.rekey(key[:])
+}
@@ -0,0 +1,94 @@
+// drbg_test.go - test the fast-key-erasure random number generator.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestDRBG(t *testing.T) {
+	seed := bytes.Clone(key)
+	d := NewDRBG(seed, 100) // rounded up to 128 bytes
+	if len(d.buf) != 128 {
+		t.Fatalf("NewDRBG buffer is %d bytes, want 128", len(d.buf))
+	}
+	if d.x.parallel {
+		t.Errorf("NewDRBG's context uses parallel processing, copying its key")
+	}
+
+	// The first output is key stream bytes 32-127 of the seed; the next
+	// key is bytes 0-31.
+	ks := make([]byte, 128)
+	New(seed, make([]byte, 8)).Keystream(ks)
+	got := make([]byte, 96)
+	d.Read(got[:10])
+	d.Read(got[10:])
+	if !bytes.Equal(got, ks[32:]) {
+		t.Errorf("first DRBG output is not the seed's key stream")
+	}
+	New(ks[:32], make([]byte, 8)).Keystream(ks)
+	d.Read(got[:1])
+	if got[0] != ks[32] {
+		t.Errorf("DRBG didn't rekey with the first 32 key stream bytes")
+	}
+	for i := 0; i < d.n; i++ {
+		if d.buf[i] != 0 {
+			t.Fatalf("used DRBG buffer byte %d was not erased", i)
+		}
+	}
+
+	// The same seed and reads give the same output, including reads that
+	// bypass the buffer; Reseed changes it.
+	d1, d2 := NewDRBG(seed, 0), NewDRBG(seed, 0)
+	lengths := []int{1, 1000, 5000, 100_000, 3}
+	for i := 0; i < len(lengths); i++ {
+		a, b := make([]byte, lengths[i]), make([]byte, lengths[i])
+		d1.Read(a)
+		d2.Read(b)
+		if !bytes.Equal(a, b) {
+			t.Errorf("read %d of %d bytes differs", i, lengths[i])
+		}
+	}
+	d2.Reseed([]byte("entropy"))
+	a, b := make([]byte, 64), make([]byte, 64)
+	d1.Read(a)
+	d2.Read(b)
+	if bytes.Equal(a, b) {
+		t.Errorf("Reseed didn't change the output")
+	}
+
+	// Concurrent reads; go test -race checks the locking.
+	d = NewDRBG(nil, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			b := make([]byte, n)
+			for j := 0; j < 20; j++ {
+				d.Read(b)
+			}
+		}(i * 300)
+	}
+	wg.Wait()
+
+	defer func() {
+		if r := recover(); r != ErrInvalidKeySize {
+			t.Errorf("NewDRBG with a 16-byte seed: recovered %v", r)
+		}
+	}()
+	NewDRBG(seed[:16], 0)
+}
+
+func BenchmarkDRBG_Read64(b *testing.B) {
+	d := NewDRBG(nil, 0)
+	p := make([]byte, 64)
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		d.Read(p)
+	}
+}
@@ -0,0 +1,104 @@
+// file.go - public domain random access to a ChaCha20-encrypted file.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// File decrypts and encrypts at any byte offset of an io.ReaderAt and
+// io.WriterAt with XORKeyStreamAt, so reading part of a large encrypted
+// file doesn't require decrypting everything before it.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"errors"
+	"io"
+)
+
+// fileBufLen is the most bytes WriteAt encrypts at a time.  It is long
+// enough to be parallel processed.
+const fileBufLen = 1 << 20
+
+// File gives plaintext random access to a file, or anything else with
+// ReadAt and WriteAt methods, whose byte at offset i is the plaintext byte
+// at offset i XORed with key stream byte i.  It implements io.ReaderAt and
+// io.WriterAt.  A File is safe for concurrent use to the extent that its
+// io.ReaderAt and io.WriterAt are; *os.File is.
+//
+// The encryption is not authenticated: ReadAt can't detect tampering.  Also,
+// overwriting part of the file with different plaintext reuses key stream,
+// which reveals the XOR of the old and new plaintext to anyone who has both
+// versions of the file.  Only write each offset once per key and iv.
+type File struct {
+	r io.ReaderAt
+	w io.WriterAt
+	x *Ctx
+}
+
+// NewFile returns a File that reads encrypted bytes from r and writes them
+// to w.  Either may be nil if only WriteAt or only ReadAt is used; for an
+// *os.File f pass f for both.  key and iv are as for New; NewFile panics,
+// as New does, if their lengths are invalid.
+func NewFile(r io.ReaderAt, w io.WriterAt, key, iv []byte) *File {
+	return NewFileCtx(r, w, New(key, iv))
+}
+
+// NewFileCtx is like NewFile but uses x's key, iv, rounds and parallel
+// processing settings, e.g. those of a context from NewIETF or NewX.  The
+// File only reads x, whose position doesn't matter; x must not be changed
+// while the File is in use.
+func NewFileCtx(r io.ReaderAt, w io.WriterAt, x *Ctx) *File {
+	return &File{r: r, w: w, x: x}
+}
+
+// ReadAt reads len(p) encrypted bytes from f's io.ReaderAt at offset off,
+// decrypts them into p and returns the number of bytes read and any error
+// as io.ReaderAt does.  The n bytes read are decrypted even when err is not
+// nil, e.g. io.EOF.
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.r == nil {
+		return 0, errors.New("chacha20.File.ReadAt: File has no io.ReaderAt")
+	}
+	if err = f.check(len(p), off); err != nil {
+		return 0, err
+	}
+	n, err = f.r.ReadAt(p, off)
+	f.x.XORKeyStreamAt(p[:n], p[:n], uint64(off))
+	return n, err
+}
+
+// WriteAt encrypts p and writes it to f's io.WriterAt at offset off.  It
+// returns the number of bytes of p written and any error that stopped the
+// write early, as io.WriterAt does.  p itself is not modified.
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
+	if f.w == nil {
+		return 0, errors.New("chacha20.File.WriteAt: File has no io.WriterAt")
+	}
+	if err = f.check(len(p), off); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, min(len(p), fileBufLen))
+	for n < len(p) {
+		b := buf[:min(len(p)-n, len(buf))]
+		pos := off + int64(n)
+		f.x.XORKeyStreamAt(b, p[n:n+len(b)], uint64(pos))
+		k, err := f.w.WriteAt(b, pos)
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// check returns an error if off is negative or the key stream of f ends
+// before byte off+size.
+func (f *File) check(size int, off int64) error {
+	if off < 0 {
+		return errors.New("chacha20.File: negative offset")
+	}
+	if f.x.ietf && uint64(off)+uint64(size) > (f.x.maxCounter()+1)*blockLen {
+		return ErrKeystreamExhausted
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// file_test.go - test random access to a ChaCha20-encrypted file.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	plain := make([]byte, 3_000_000)
+	crand.Read(plain)
+	want := make([]byte, len(plain))
+	NewSmallMemory(key, iv).Encrypt(plain, want)
+
+	fp, err := os.Create(filepath.Join(t.TempDir(), "encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	f := NewFile(fp, fp, key, iv)
+
+	// Write out of order, in unaligned pieces, some longer than fileBufLen.
+	cuts := []int{0, 1, 65, 100_000, 100_001, 2_500_000, len(plain)}
+	saved := bytes.Clone(plain)
+	for i := len(cuts) - 2; i >= 0; i-- {
+		if n, err := f.WriteAt(plain[cuts[i]:cuts[i+1]], int64(cuts[i])); err != nil ||
+			n != cuts[i+1]-cuts[i] {
+			t.Fatalf("WriteAt at %d = %d, %v", cuts[i], n, err)
+		}
+	}
+	if !bytes.Equal(plain, saved) {
+		t.Errorf("WriteAt modified its argument")
+	}
+	got, err := os.ReadFile(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("file written with WriteAt differs from Encrypt")
+	}
+
+	// Random reads.
+	spans := [][2]int{{0, 10}, {63, 2}, {1_234_567, 70_000}, {len(plain) - 1, 1}}
+	for i := 0; i < len(spans); i++ {
+		off, size := spans[i][0], spans[i][1]
+		p := make([]byte, size)
+		if n, err := f.ReadAt(p, int64(off)); n != size || err != nil {
+			t.Errorf("ReadAt(%d bytes at %d) = %d, %v", size, off, n, err)
+		}
+		if !bytes.Equal(p, plain[off:off+size]) {
+			t.Errorf("ReadAt(%d bytes at %d) is wrong", size, off)
+		}
+	}
+	p := make([]byte, 100)
+	if n, err := f.ReadAt(p, int64(len(plain)-40)); n != 40 || err != io.EOF ||
+		!bytes.Equal(p[:n], plain[len(plain)-40:]) {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+	if _, err := f.ReadAt(p, -1); err == nil {
+		t.Errorf("ReadAt at a negative offset succeeded")
+	}
+	if _, err := NewFile(nil, fp, key, iv).ReadAt(p, 0); err == nil {
+		t.Errorf("ReadAt without an io.ReaderAt succeeded")
+	}
+
+	// The end of an IETF key stream.
+	ietf := NewFileCtx(fp, fp, NewIETF(key, make([]byte, 12)))
+	if _, err := ietf.WriteAt(p, 1<<38-99); err != ErrKeystreamExhausted {
+		t.Errorf("IETF WriteAt beyond the key stream: err = %v", err)
+	}
+}
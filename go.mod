@@ -0,0 +1,3 @@
+module github.com/charltoncr/chacha20
+
+go 1.21
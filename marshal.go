@@ -0,0 +1,171 @@
+// marshal.go - public domain snapshots of a ChaCha20 context's state.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// MarshalBinary and UnmarshalBinary let a long-running job checkpoint a
+// Ctx and later resume its key stream at exactly the same byte.  The key is
+// left out unless the caller asks for it with MarshalBinaryWithKey.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Ctx state format, version 1, all integers little-endian:
+//
+//	magic          4 bytes  "cc20"
+//	version        1 byte   1
+//	flags          1 byte   ctxFlag* bits
+//	rounds         1 byte   8, 12 or 20
+//	next           1 byte   0-64, offset of the next byte of the current block
+//	constants     16 bytes  x.input[0:4]; "expand 32-byte k" or "expand 16-byte k"
+//	counter/nonce 16 bytes  x.input[12:16]
+//	blocksPerChunk 4 bytes
+//	goroutinesMax  4 bytes
+//	minParallel    8 bytes
+//	key           32 bytes  x.input[4:12]; only if ctxFlagKey is set
+const (
+	ctxMagic      = "cc20"
+	ctxVersion    = 1
+	ctxStateLen   = 4 + 4 + 16 + 16 + 4 + 4 + 8
+	ctxKeyLen     = 32
+	ctxFlagIETF   = 1 << 0
+	ctxFlagEOF    = 1 << 1
+	ctxFlagPar    = 1 << 2
+	ctxFlagKey    = 1 << 3
+	ctxFlagsValid = ctxFlagIETF | ctxFlagEOF | ctxFlagPar | ctxFlagKey
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler.  It returns a
+// versioned snapshot of x's state: its nonce, block counter and offset
+// within the current block, rounds, variant (NewIETF or not) and parallel
+// processing settings.  The key is not included; see MarshalBinaryWithKey.
+// x's ExhaustionPolicy, RekeyFunc and Pool are not included either.
+func (x *Ctx) MarshalBinary() ([]byte, error) {
+	return x.marshal(false), nil
+}
+
+// MarshalBinaryWithKey is like MarshalBinary but includes x's key, so that
+// UnmarshalBinary can restore x into any Ctx, even a zero one.  Protect the
+// result as you would the key itself.  For a context from NewX the key
+// included is the subkey HChaCha20 derived from the key and nonce.
+func (x *Ctx) MarshalBinaryWithKey() ([]byte, error) {
+	return x.marshal(true), nil
+}
+
+// marshal does the work of MarshalBinary and MarshalBinaryWithKey.
+func (x *Ctx) marshal(withKey bool) []byte {
+	b := make([]byte, 0, ctxStateLen+ctxKeyLen)
+	b = append(b, ctxMagic...)
+	var flags byte
+	if x.ietf {
+		flags |= ctxFlagIETF
+	}
+	if x.eof {
+		flags |= ctxFlagEOF
+	}
+	if x.parallel {
+		flags |= ctxFlagPar
+	}
+	if withKey {
+		flags |= ctxFlagKey
+	}
+	b = append(b, ctxVersion, flags, byte(x.rounds), byte(x.next))
+	for i := 0; i < 4; i++ {
+		b = binary.LittleEndian.AppendUint32(b, x.input[i])
+	}
+	for i := 12; i < 16; i++ {
+		b = binary.LittleEndian.AppendUint32(b, x.input[i])
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(x.blocksPerChunk))
+	b = binary.LittleEndian.AppendUint32(b, uint32(x.goroutinesMax))
+	b = binary.LittleEndian.AppendUint64(b, uint64(x.minParallel))
+	if withKey {
+		for i := 4; i < 12; i++ {
+			b = binary.LittleEndian.AppendUint32(b, x.input[i])
+		}
+	}
+	return b
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  It restores a
+// state from MarshalBinary or MarshalBinaryWithKey into x, so that x
+// continues the key stream at the byte where the snapshot was taken.  If
+// data has no key, x must already have the key the snapshot was taken
+// with, e.g. from New or NewIETF with the original key and any valid
+// nonce, whose nonce the snapshot's replaces.  A context from NewX needs
+// the original nonce too, from which its subkey is derived.  x's
+// ExhaustionPolicy, RekeyFunc and Pool are kept, and Err is reset.
+// UnmarshalBinary returns an error, leaving x unchanged, if data is not a
+// valid snapshot or x's key size differs from the snapshot's.
+func (x *Ctx) UnmarshalBinary(data []byte) error {
+	if len(data) < ctxStateLen || string(data[:4]) != ctxMagic {
+		return errors.New("chacha20.Ctx.UnmarshalBinary: invalid state")
+	}
+	if data[4] != ctxVersion {
+		return errors.New("chacha20.Ctx.UnmarshalBinary: unsupported state version")
+	}
+	flags, rounds, next := data[5], int(data[6]), int(data[7])
+	withKey := flags&ctxFlagKey != 0
+	want := ctxStateLen
+	if withKey {
+		want += ctxKeyLen
+	}
+	constants := data[8:24]
+	if flags&^ctxFlagsValid != 0 || len(data) != want || next > blockLen ||
+		!validRounds(rounds) ||
+		!bytes.Equal(constants, sigma) && !bytes.Equal(constants, tau) {
+		return errors.New("chacha20.Ctx.UnmarshalBinary: invalid state")
+	}
+	if !withKey {
+		for i := 0; i < 4; i++ {
+			if x.input[i] != binary.LittleEndian.Uint32(constants[4*i:]) {
+				return errors.New("chacha20.Ctx.UnmarshalBinary: " +
+					"x's key size differs from the state's, or x has no key")
+			}
+		}
+	}
+	blocksPerChunk := int(binary.LittleEndian.Uint32(data[40:]))
+	goroutinesMax := int(binary.LittleEndian.Uint32(data[44:]))
+	minParallel := binary.LittleEndian.Uint64(data[48:])
+	if blocksPerChunk <= 0 || goroutinesMax <= 0 || minParallel > 1<<31-1 {
+		return errors.New("chacha20.Ctx.UnmarshalBinary: invalid parallel processing settings")
+	}
+
+	for i := 0; i < 4; i++ {
+		x.input[i] = binary.LittleEndian.Uint32(data[8+4*i:])
+		x.input[12+i] = binary.LittleEndian.Uint32(data[24+4*i:])
+	}
+	if withKey {
+		for i := 0; i < 8; i++ {
+			x.input[4+i] = binary.LittleEndian.Uint32(data[ctxStateLen+4*i:])
+		}
+	}
+	x.ietf = flags&ctxFlagIETF != 0
+	x.eof = flags&ctxFlagEOF != 0
+	x.parallel = flags&ctxFlagPar != 0
+	x.rounds = rounds
+	x.next = next
+	x.err = nil
+	x.blocksPerChunk = blocksPerChunk
+	x.minParallel = int(minParallel)
+	if x.guard == nil || x.goroutinesMax != goroutinesMax {
+		x.goroutinesMax = goroutinesMax
+		x.guard = make(chan struct{}, goroutinesMax)
+	}
+	if x.next < blockLen {
+		// Recompute the current block, whose counter x has passed.
+		in := x.input
+		in[12]--
+		if !x.ietf && in[12] == 0xffffffff {
+			in[13]--
+		}
+		salsa20_wordtobyte(in[:], x.rounds, x.output[:])
+	}
+	return nil
+}
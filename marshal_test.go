@@ -0,0 +1,102 @@
+// marshal_test.go - test snapshots of a ChaCha20 context's state.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding"
+	"testing"
+)
+
+var _ encoding.BinaryMarshaler = &Ctx{}
+var _ encoding.BinaryUnmarshaler = &Ctx{}
+
+func TestMarshalBinary(t *testing.T) {
+	key16 := key[:16]
+	xkey, xnonce := make([]byte, 32), make([]byte, 24)
+	copy(xkey, key)
+	copy(xnonce, "a 24-byte XChaCha nonce.")
+	ietfNonce := []byte("a 12-b nonce")
+	otherIV := []byte("other iv")
+	newCtxs := []func() *Ctx{
+		func() *Ctx { return New(key, iv) },
+		func() *Ctx { return New(key16, iv) },
+		func() *Ctx { return NewIETF(key, ietfNonce) },
+		func() *Ctx { return NewX(xkey, xnonce) },
+		func() *Ctx { x := New(key, iv); x.SetRounds(8); x.TuneParallel(30, 7); return x },
+	}
+	// How to make a Ctx with the same key, mostly with a different nonce.
+	sameKey := []func() *Ctx{
+		func() *Ctx { return New(key, otherIV) },
+		func() *Ctx { return New(key16, otherIV) },
+		func() *Ctx { return NewIETF(key, make([]byte, 12)) },
+		func() *Ctx { return NewX(xkey, xnonce) }, // the subkey depends on the nonce
+		func() *Ctx { return New(key, otherIV) },
+	}
+	for i := 0; i < len(newCtxs); i++ {
+		x := newCtxs[i]()
+		x.Read(make([]byte, 1000+i)) // not on a block boundary
+		state, err := x.MarshalBinary()
+		if err != nil || len(state) != ctxStateLen {
+			t.Fatalf("%d: MarshalBinary = %d bytes, %v", i, len(state), err)
+		}
+		keyed, _ := x.MarshalBinaryWithKey()
+		want := make([]byte, 100_000)
+		x.Read(want)
+
+		y := sameKey[i]()
+		if err := y.UnmarshalBinary(state); err != nil {
+			t.Fatalf("%d: UnmarshalBinary: %v", i, err)
+		}
+		z := &Ctx{}
+		if err := z.UnmarshalBinary(keyed); err != nil {
+			t.Fatalf("%d: UnmarshalBinary with key into a zero Ctx: %v", i, err)
+		}
+		if y.blocksPerChunk != x.blocksPerChunk || y.goroutinesMax != x.goroutinesMax ||
+			y.rounds != x.rounds || y.ietf != x.ietf {
+			t.Errorf("%d: UnmarshalBinary didn't restore the settings", i)
+		}
+		got := make([]byte, len(want))
+		y.Read(got)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d: key stream after UnmarshalBinary differs", i)
+		}
+		z.Read(got)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d: key stream after UnmarshalBinary with key differs", i)
+		}
+	}
+
+	// Invalid states leave x unchanged.
+	x := New(key, iv)
+	x.Read(make([]byte, 10))
+	before, _ := x.MarshalBinaryWithKey()
+	state, _ := x.MarshalBinary()
+	bad := [][]byte{
+		nil,
+		state[:len(state)-1],
+		append(bytes.Clone(state), 0),
+		append([]byte("cc21"), state[4:]...),
+	}
+	for i := 4; i <= 7; i++ { // version, flags, rounds, next at offsets 4-7
+		b := bytes.Clone(state)
+		b[i] = 0xff
+		bad = append(bad, b)
+	}
+	for i := 0; i < len(bad); i++ {
+		if err := x.UnmarshalBinary(bad[i]); err == nil {
+			t.Errorf("UnmarshalBinary of invalid state %d succeeded", i)
+		}
+	}
+	if err := (&Ctx{}).UnmarshalBinary(state); err == nil {
+		t.Errorf("UnmarshalBinary without a key into a zero Ctx succeeded")
+	}
+	if err := New(key16, iv).UnmarshalBinary(state); err == nil {
+		t.Errorf("UnmarshalBinary into a Ctx with another key size succeeded")
+	}
+	if after, _ := x.MarshalBinaryWithKey(); !bytes.Equal(after, before) {
+		t.Errorf("failed UnmarshalBinary changed x")
+	}
+}
@@ -0,0 +1,203 @@
+// poly1305.go - public domain Poly1305 one-time authenticator.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// See https://datatracker.ietf.org/doc/html/rfc8439#section-2.5
+// for a description of Poly1305.
+//
+// The accumulator h and the clamped key r are held in 64-bit limbs and
+// multiplied with math/bits, so no big-integer arithmetic is needed.
+// All arithmetic is constant time with respect to the key and message.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/bits"
+)
+
+// Poly1305KeySize is the length in bytes of a Poly1305 one-time key.
+const Poly1305KeySize = 32
+
+// Poly1305TagSize is the length in bytes of a Poly1305 tag.
+const Poly1305TagSize = 16
+
+// Poly1305 is a streaming Poly1305 message authentication code.  It
+// implements the hash.Hash interface.  A Poly1305 key must be used for
+// only one message; Ctx.Poly1305Key derives a fresh one from a ChaCha20 key
+// stream.
+type Poly1305 struct {
+	r   [2]uint64 // clamped first half of the key
+	s   [2]uint64 // second half of the key, added at the end
+	h   [3]uint64 // accumulator, partially reduced mod 2^130-5
+	buf [Poly1305TagSize]byte
+	n   int // number of bytes in buf
+}
+
+// NewPoly1305 returns a Poly1305 MAC that uses the 32-byte one-time key.
+// NewPoly1305 panics if len(key) is not 32.
+func NewPoly1305(key []byte) (p *Poly1305) {
+	if len(key) != Poly1305KeySize {
+		panic("chacha20.NewPoly1305: invalid key length; must be 32 bytes.")
+	}
+	p = &Poly1305{}
+	p.r[0] = binary.LittleEndian.Uint64(key[0:]) & 0x0ffffffc0fffffff
+	p.r[1] = binary.LittleEndian.Uint64(key[8:]) & 0x0ffffffc0ffffffc
+	p.s[0] = binary.LittleEndian.Uint64(key[16:])
+	p.s[1] = binary.LittleEndian.Uint64(key[24:])
+	return
+}
+
+// Size returns Poly1305's tag length, 16.
+func (p *Poly1305) Size() int { return Poly1305TagSize }
+
+// BlockSize returns Poly1305's block length, 16.
+func (p *Poly1305) BlockSize() int { return Poly1305TagSize }
+
+// Reset discards all data written to p.  p keeps its key.
+func (p *Poly1305) Reset() {
+	p.h = [3]uint64{}
+	p.n = 0
+}
+
+// Write adds b to the message authenticated by p.  It never returns an
+// error.
+func (p *Poly1305) Write(b []byte) (int, error) {
+	n := len(b)
+	if p.n > 0 {
+		k := copy(p.buf[p.n:], b)
+		p.n += k
+		b = b[k:]
+		if p.n < Poly1305TagSize {
+			return n, nil
+		}
+		p.blocks(p.buf[:], 1)
+		p.n = 0
+	}
+	if full := len(b) &^ (Poly1305TagSize - 1); full > 0 {
+		p.blocks(b[:full], 1)
+		b = b[full:]
+	}
+	p.n = copy(p.buf[:], b)
+	return n, nil
+}
+
+// Sum appends the 16-byte tag of the message written so far to b and
+// returns the resulting slice.  It does not change p's state.
+func (p *Poly1305) Sum(b []byte) []byte {
+	var tag [Poly1305TagSize]byte
+	q := *p
+	if q.n > 0 {
+		// Pad the final partial block with a one byte followed by zeros in
+		// place of the 2^128 bit that full blocks get.
+		q.buf[q.n] = 1
+		clear(q.buf[q.n+1:])
+		q.blocks(q.buf[:], 0)
+	}
+	q.finish(&tag)
+	return append(b, tag[:]...)
+}
+
+// Verify reports in constant time whether tag is the tag of the message
+// written to p so far.
+func (p *Poly1305) Verify(tag []byte) bool {
+	return subtle.ConstantTimeCompare(p.Sum(nil), tag) == 1
+}
+
+// Poly1305Sum returns the 16-byte Poly1305 tag of msg under the 32-byte
+// one-time key.  Poly1305Sum panics if len(key) is not 32.
+func Poly1305Sum(key, msg []byte) (tag []byte) {
+	p := NewPoly1305(key)
+	p.Write(msg)
+	return p.Sum(nil)
+}
+
+// Poly1305Verify reports in constant time whether tag is the Poly1305 tag
+// of msg under the 32-byte one-time key.  Poly1305Verify panics if
+// len(key) is not 32.
+func Poly1305Verify(key, msg, tag []byte) bool {
+	return subtle.ConstantTimeCompare(Poly1305Sum(key, msg), tag) == 1
+}
+
+// Poly1305Key returns a 32-byte Poly1305 one-time key taken from block 0 of
+// x's key stream, as RFC 8439 section 2.6 does, and leaves x positioned at
+// block 1 ready to encrypt the message.
+func (x *Ctx) Poly1305Key() (key []byte) {
+	var block [blockLen]byte
+	x.Seek(0)
+	x.Keystream(block[:])
+	return block[:Poly1305KeySize]
+}
+
+// blocks adds each 16-byte block of m to p's accumulator and multiplies
+// the accumulator by r mod 2^130-5.  hibit is 1 for full blocks and 0 for
+// a padded final block.
+func (p *Poly1305) blocks(m []byte, hibit uint64) {
+	h0, h1, h2 := p.h[0], p.h[1], p.h[2]
+	r0, r1 := p.r[0], p.r[1]
+	var c uint64
+
+	for len(m) >= Poly1305TagSize {
+		// h += m
+		h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(m[0:]), 0)
+		h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(m[8:]), c)
+		h2 += c + hibit
+
+		// t = h * r.  Clamping keeps r0, r1 < 2^60 and h2 is small, so
+		// the partial products can be summed without overflow.
+		h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+		h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+		h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+		h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+		h2r0 := h2 * r0
+		h2r1 := h2 * r1
+
+		t0 := h0r0lo
+		t1, c := bits.Add64(h1r0lo, h0r1lo, 0)
+		m1hi := h1r0hi + h0r1hi + c
+		t1, c = bits.Add64(t1, h0r0hi, 0)
+		t2, c2 := bits.Add64(h1r1lo, h2r0, 0)
+		m2hi := h1r1hi + c2
+		t2, c2 = bits.Add64(t2, m1hi, c)
+		t3 := h2r1 + m2hi + c2
+
+		// Reduce t mod 2^130-5: since 2^130 = 5, the bits above 2^130,
+		// cc = t >> 130, fold back in as 5*cc = 4*cc + cc.
+		h0, h1, h2 = t0, t1, t2&3
+		cclo, cchi := t2&^3, t3
+		h0, c = bits.Add64(h0, cclo, 0)
+		h1, c = bits.Add64(h1, cchi, c)
+		h2 += c
+		cclo = cclo>>2 | cchi<<62
+		cchi >>= 2
+		h0, c = bits.Add64(h0, cclo, 0)
+		h1, c = bits.Add64(h1, cchi, c)
+		h2 += c
+
+		m = m[Poly1305TagSize:]
+	}
+	p.h[0], p.h[1], p.h[2] = h0, h1, h2
+}
+
+// finish fully reduces the accumulator, adds s and writes the tag.
+func (p *Poly1305) finish(tag *[Poly1305TagSize]byte) {
+	h0, h1, h2 := p.h[0], p.h[1], p.h[2]
+
+	// h is less than 2*(2^130-5) here.  Subtract 2^130-5, and keep the
+	// result only if that didn't borrow.
+	t0, b := bits.Sub64(h0, 0xfffffffffffffffb, 0)
+	t1, b := bits.Sub64(h1, 0xffffffffffffffff, b)
+	_, b = bits.Sub64(h2, 3, b)
+	mask := -b // all ones when h < 2^130-5
+	h0 = t0 ^ ((h0 ^ t0) & mask)
+	h1 = t1 ^ ((h1 ^ t1) & mask)
+
+	var c uint64
+	h0, c = bits.Add64(h0, p.s[0], 0)
+	h1, _ = bits.Add64(h1, p.s[1], c)
+	binary.LittleEndian.PutUint64(tag[0:], h0)
+	binary.LittleEndian.PutUint64(tag[8:], h1)
+}
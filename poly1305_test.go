@@ -0,0 +1,137 @@
+// poly1305_test.go - test the Poly1305 one-time authenticator.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+// Test interface compatability.
+var _ hash.Hash = &Poly1305{}
+
+// unhex decodes a hex string that is known to be valid.
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// poly1305Tests are from RFC 8439 section 2.5.2 and appendix A.3.
+var poly1305Tests = []struct {
+	key, msg, tag string
+}{
+	{ // section 2.5.2
+		"85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b",
+		hex.EncodeToString([]byte("Cryptographic Forum Research Group")),
+		"a8061dc1305136c6c22b8baf0c0127a9",
+	},
+	{ // A.3 #1
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+			"0000000000000000000000000000000000000000000000000000000000000000",
+		"00000000000000000000000000000000",
+	},
+	{ // A.3 #5
+		"0200000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff",
+		"03000000000000000000000000000000",
+	},
+	{ // A.3 #6
+		"02000000000000000000000000000000ffffffffffffffffffffffffffffffff",
+		"02000000000000000000000000000000",
+		"03000000000000000000000000000000",
+	},
+	{ // A.3 #7
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff" +
+			"f0ffffffffffffffffffffffffffffff" +
+			"11000000000000000000000000000000",
+		"05000000000000000000000000000000",
+	},
+	{ // A.3 #8
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff" +
+			"fbfefefefefefefefefefefefefefefe" +
+			"01010101010101010101010101010101",
+		"00000000000000000000000000000000",
+	},
+	{ // A.3 #9
+		"0200000000000000000000000000000000000000000000000000000000000000",
+		"fdffffffffffffffffffffffffffffff",
+		"faffffffffffffffffffffffffffffff",
+	},
+	{ // A.3 #10
+		"0100000000000000040000000000000000000000000000000000000000000000",
+		"e33594d7505e43b900000000000000003394d7505e4379cd0100000000000000" +
+			"0000000000000000000000000000000001000000000000000000000000000000",
+		"14000000000000005500000000000000",
+	},
+	{ // A.3 #11
+		"0100000000000000040000000000000000000000000000000000000000000000",
+		"e33594d7505e43b900000000000000003394d7505e4379cd0100000000000000" +
+			"00000000000000000000000000000000",
+		"13000000000000000000000000000000",
+	},
+}
+
+func TestPoly1305(t *testing.T) {
+	for i := 0; i < len(poly1305Tests); i++ {
+		key := unhex(poly1305Tests[i].key)
+		msg := unhex(poly1305Tests[i].msg)
+		want := unhex(poly1305Tests[i].tag)
+
+		if got := Poly1305Sum(key, msg); !bytes.Equal(got, want) {
+			t.Errorf("Poly1305Sum test %d:\n got %x\nwant %x", i, got, want)
+		}
+		if !Poly1305Verify(key, msg, want) {
+			t.Errorf("Poly1305Verify test %d: valid tag rejected", i)
+		}
+		bad := append([]byte{}, want...)
+		bad[0] ^= 1
+		if Poly1305Verify(key, msg, bad) {
+			t.Errorf("Poly1305Verify test %d: invalid tag accepted", i)
+		}
+
+		// Streaming in uneven pieces must give the same tag, and Sum must
+		// not disturb the state.
+		p := NewPoly1305(key)
+		for j := 0; j < len(msg); j += 7 {
+			end := min(j+7, len(msg))
+			p.Write(msg[j:end])
+			p.Sum(nil)
+		}
+		if got := p.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("Poly1305 Write test %d:\n got %x\nwant %x", i, got, want)
+		}
+		if !p.Verify(want) {
+			t.Errorf("Poly1305 Verify test %d: valid tag rejected", i)
+		}
+		p.Reset()
+		p.Write(msg)
+		if got := p.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("Poly1305 Reset test %d:\n got %x\nwant %x", i, got, want)
+		}
+	}
+}
+
+func TestPoly1305Key(t *testing.T) {
+	// RFC 8439 section 2.6.2 test vector.
+	key := unhex("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := unhex("000000000001020304050607")
+	want := unhex("8ad5a08b905f81cc815040274ab29471a833b637e3fd0da508dbb8e2fdd1a646")
+	ctx := NewIETF(key, nonce)
+	ctx.Seek(5)
+	if got := ctx.Poly1305Key(); !bytes.Equal(got, want) {
+		t.Errorf("Poly1305Key:\n got %x\nwant %x", got, want)
+	}
+	if c := ctx.GetCounter(); c != 1 {
+		t.Errorf("Poly1305Key counter: got %d want 1", c)
+	}
+}
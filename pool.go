@@ -0,0 +1,135 @@
+// pool.go - public domain persistent worker pool for parallel processing.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// By default Encrypt starts a goroutine for every chunk of a long message.
+// A Pool instead keeps a fixed set of long-lived workers that are fed
+// (block counter, offset, length) jobs, and it can be shared by any number
+// of Ctx values.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Pool is a set of worker goroutines that process the parallel chunks of
+// Encrypt and the methods that depend on it.  A Pool is safe for concurrent
+// use, and several Ctx values can share one Pool through UsePool.  The
+// workers run until Close is called.
+type Pool struct {
+	jobs    chan poolJob
+	workers int
+	wg      sync.WaitGroup // running workers
+	mu      sync.RWMutex   // write-locked only to close jobs
+	closed  bool
+}
+
+// poolWork is what the chunks of one Encrypt call share.
+type poolWork struct {
+	ctx  Ctx // copy of the Ctx being processed; read-only for workers
+	m, c []byte
+	wg   *sync.WaitGroup
+}
+
+// poolJob is one chunk: XOR m[off:end] with the key stream starting at
+// block blk into c[off:end].
+type poolJob struct {
+	work     *poolWork
+	blk      uint64
+	off, end int
+}
+
+// NewPool starts a Pool of workers goroutines.  If workers <= 0 the Pool
+// has runtime.GOMAXPROCS(0) workers, which suits most uses; a CPU-bound
+// worker beyond that only adds scheduling overhead.
+func NewPool(workers int) *Pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &Pool{
+		jobs:    make(chan poolJob, workers),
+		workers: workers,
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Workers returns the number of worker goroutines p was started with.
+func (p *Pool) Workers() int {
+	return p.workers
+}
+
+// Close stops p's workers after they finish the chunks already given to
+// them, and waits for them to exit.  A Ctx that still uses p after Close
+// falls back to starting a goroutine per chunk.  Calling Close more than
+// once is harmless.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// worker processes jobs until p is closed.
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for {
+		j, ok := <-p.jobs
+		if !ok {
+			return
+		}
+		r := j.work.ctx
+		r.xorChunk(j.work.m, j.work.c, j.blk, j.off, j.end)
+		j.work.wg.Done()
+	}
+}
+
+// run queues chunks chunks of blocksPerChunk blocks each of m, starting at
+// byte n and block blk, adding each to wg.  It stops early once done, if
+// not nil, is closed, and returns the number of chunks queued.  It returns
+// ok false, having queued nothing, if p is closed.
+func (p *Pool) run(x *Ctx, m, c []byte, blk uint64, n int, chunks uint64,
+	blocksPerChunk int, wg *sync.WaitGroup, done <-chan struct{}) (queued uint64, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return 0, false
+	}
+	w := &poolWork{ctx: *x, m: m, c: c, wg: wg}
+	chunkLen := blocksPerChunk * blockLen
+	for ; queued < chunks; queued++ {
+		if isDone(done) {
+			break
+		}
+		wg.Add(1)
+		select {
+		case p.jobs <- poolJob{work: w, blk: blk, off: n, end: n + chunkLen}:
+		case <-done:
+			wg.Done()
+			return queued, true
+		}
+		blk += uint64(blocksPerChunk)
+		n += chunkLen
+	}
+	return queued, true
+}
+
+// UsePool makes x process the chunks of long messages with p's workers
+// instead of starting a goroutine for each chunk.  MaxGoroutines given to
+// TuneParallel does not apply then; p's number of workers limits
+// concurrency instead.  UsePool(nil) restores the default.  UsePool has no
+// effect while parallel processing is disabled by UseParallel(false) or
+// NewSmallMemory.
+func (x *Ctx) UsePool(p *Pool) {
+	x.pool = p
+}
@@ -0,0 +1,102 @@
+// pool_test.go - test and benchmark the persistent worker pool.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	key := make([]byte, 32)
+	crand.Read(key)
+	ivs := make([][]byte, 4)
+	for i := 0; i < len(ivs); i++ {
+		ivs[i] = make([]byte, 8)
+		crand.Read(ivs[i])
+	}
+	m := make([]byte, blocksPerChunk*blockLen*9+123)
+	crand.Read(m)
+	want := make([][]byte, len(ivs))
+	for i := 0; i < len(ivs); i++ {
+		want[i] = make([]byte, len(m))
+		NewSmallMemory(key, ivs[i]).Encrypt(m, want[i])
+	}
+
+	p := NewPool(0)
+	if p.Workers() < 1 {
+		t.Fatalf("NewPool(0) has %d workers", p.Workers())
+	}
+
+	// Several Ctx values share p concurrently, each in several calls.
+	var wg sync.WaitGroup
+	got := make([][]byte, len(ivs))
+	for i := 0; i < len(ivs); i++ {
+		got[i] = make([]byte, len(m))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			x := New(key, ivs[i])
+			x.UsePool(p)
+			x.Encrypt(m[:100], got[i][:100])
+			x.Encrypt(m[100:], got[i][100:])
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < len(ivs); i++ {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("pool Encrypt %d differs from serial Encrypt", i)
+		}
+	}
+
+	// After Close a Ctx using p falls back to a goroutine per chunk.
+	p.Close()
+	p.Close()
+	x := New(key, ivs[0])
+	x.UsePool(p)
+	c := make([]byte, len(m))
+	x.Encrypt(m, c)
+	if !bytes.Equal(c, want[0]) {
+		t.Errorf("Encrypt with closed pool differs from serial Encrypt")
+	}
+}
+
+func BenchmarkChaCha_Pool20rnds(b *testing.B) {
+	b.SetBytes(int64(len(m5e6)))
+	p := NewPool(0)
+	defer p.Close()
+	x := New(key, iv)
+	x.UsePool(p)
+	for i := 0; i < b.N; i++ {
+		x.Encrypt(m5e6, m5e6)
+	}
+}
+
+// benchmarkShared encrypts 1 MB messages from many goroutines, each with its
+// own Ctx, with p shared by all of them, or with a goroutine per chunk if
+// p is nil.
+func benchmarkShared(b *testing.B, p *Pool) {
+	b.SetBytes(1 << 20)
+	b.RunParallel(func(pb *testing.PB) {
+		x := New(key, iv)
+		x.UsePool(p)
+		m := make([]byte, 1<<20)
+		for pb.Next() {
+			x.Encrypt(m, m)
+		}
+	})
+}
+
+func BenchmarkShared_GoroutinePerChunk(b *testing.B) {
+	benchmarkShared(b, nil)
+}
+
+func BenchmarkShared_Pool(b *testing.B) {
+	p := NewPool(0)
+	defer p.Close()
+	benchmarkShared(b, p)
+}
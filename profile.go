@@ -0,0 +1,172 @@
+// profile.go - public domain serializable tuning profiles.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// A TuningProfile records parallel processing parameters together with a
+// fingerprint of the host they were measured on, so that a machine class
+// can be tuned once (with AutoTune or cmd/chacha20bench) and the result
+// loaded at startup.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// TuningProfile holds parallel processing parameters and the fingerprint
+// of the host they suit.  It marshals to and from JSON with
+// encoding/json; GOMAXPROCS and Throughput of its TuneParams aren't
+// saved.
+type TuningProfile struct {
+	// Parallel processing parameters; apply them with
+	// SetDefaultTuneParams(p.TuneParams).
+	TuneParams
+
+	// Fingerprint of the host the parameters were measured on.
+	Fingerprint Fingerprint `json:"fingerprint"`
+}
+
+// Fingerprint identifies a class of host for a TuningProfile.
+type Fingerprint struct {
+	GOOS       string `json:"goos"`
+	GOARCH     string `json:"goarch"`
+	CPUModel   string `json:"cpu_model,omitempty"`
+	NumCPU     int    `json:"num_cpu"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+}
+
+// ErrFingerprintMismatch is returned, wrapped, by LoadTuningProfile when a
+// profile was made for a different host than the current one.
+var ErrFingerprintMismatch = errors.New(
+	"chacha20: tuning profile fingerprint doesn't match this host")
+
+// NewTuningProfile returns a TuningProfile with p's parameters and the
+// current host's fingerprint.
+func NewTuningProfile(p TuneParams) TuningProfile {
+	return TuningProfile{TuneParams: p, Fingerprint: HostFingerprint()}
+}
+
+// HostFingerprint returns the fingerprint of the current host.  CPUModel
+// is empty where it can't be determined: on Windows, the BSDs and other
+// systems without /proc/cpuinfo.
+func HostFingerprint() Fingerprint {
+	return Fingerprint{
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		CPUModel:   cpuModel(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+	}
+}
+
+// parseCPUInfo returns the CPU model from r in /proc/cpuinfo format, or "".
+// Linux on arm64 identifies the CPU by its "CPU implementer" and "CPU part"
+// codes rather than by name, and its "Model" field, if any, names the
+// board, so those codes are preferred.
+func parseCPUInfo(r io.Reader) string {
+	var model, implementer, part string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		name, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(name) {
+		case "model name", "Model", "cpu model":
+			if model == "" {
+				model = value
+			}
+		case "CPU implementer":
+			if implementer == "" {
+				implementer = value
+			}
+		case "CPU part":
+			if part == "" {
+				part = value
+			}
+		}
+	}
+	if implementer != "" && part != "" {
+		return "CPU implementer " + implementer + " part " + part
+	}
+	return model
+}
+
+// SaveTuningProfile writes p to the named file as JSON.
+func SaveTuningProfile(name string, p TuningProfile) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// LoadTuningProfile reads a TuningProfile written as JSON, e.g. by
+// SaveTuningProfile, from the named file.  If the profile's fingerprint
+// doesn't match the current host LoadTuningProfile returns the profile
+// together with an error wrapping ErrFingerprintMismatch that describes
+// the differences; the profile is still usable, but it was tuned for
+// other hardware.  An empty CPU model, in the profile or for the host,
+// counts as a mismatch, since the hardware can't then be compared.
+// LoadTuningProfile doesn't apply the profile; use SetDefaultTuneParams
+// for that:
+//
+//	p, err := chacha20.LoadTuningProfile("chacha20.json")
+//	if errors.Is(err, chacha20.ErrFingerprintMismatch) {
+//		log.Print(err) // warn, but use it anyway
+//	} else if err != nil {
+//		log.Fatal(err)
+//	}
+//	chacha20.SetDefaultTuneParams(p.TuneParams)
+func LoadTuningProfile(name string) (p TuningProfile, err error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &p); err != nil {
+		return TuningProfile{}, fmt.Errorf("chacha20: tuning profile %s: %w", name, err)
+	}
+	if p.BlocksPerChunk < 0 || p.MaxGoroutines < 0 {
+		return TuningProfile{}, fmt.Errorf(
+			"chacha20: tuning profile %s: negative parameter", name)
+	}
+	if diff := p.Fingerprint.diff(HostFingerprint()); diff != "" {
+		err = fmt.Errorf("%w: %s: %s", ErrFingerprintMismatch, name, diff)
+	}
+	return
+}
+
+// diff describes how f differs from host, or returns "" if it doesn't.
+func (f Fingerprint) diff(host Fingerprint) string {
+	var d []string
+	add := func(field string, profile, host any) {
+		d = append(d, fmt.Sprintf("%s %v, host %v", field, profile, host))
+	}
+	if f.GOOS != host.GOOS {
+		add("GOOS", f.GOOS, host.GOOS)
+	}
+	if f.GOARCH != host.GOARCH {
+		add("GOARCH", f.GOARCH, host.GOARCH)
+	}
+	if f.CPUModel != host.CPUModel || f.CPUModel == "" {
+		add("CPU model", strconv.Quote(f.CPUModel), strconv.Quote(host.CPUModel))
+	}
+	if f.NumCPU != host.NumCPU {
+		add("NumCPU", f.NumCPU, host.NumCPU)
+	}
+	if f.GOMAXPROCS != host.GOMAXPROCS {
+		add("GOMAXPROCS", f.GOMAXPROCS, host.GOMAXPROCS)
+	}
+	return strings.Join(d, "; ")
+}
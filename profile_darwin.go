@@ -0,0 +1,21 @@
+// profile_darwin.go - CPU model for tuning profile fingerprints on darwin.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+
+//go:build darwin
+
+package chacha20
+
+import (
+	"strings"
+	"syscall"
+)
+
+// cpuModel returns the CPU model from the machdep.cpu.brand_string sysctl,
+// e.g. "Apple M2 Max", or "".
+func cpuModel() string {
+	s, err := syscall.Sysctl("machdep.cpu.brand_string")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,19 @@
+// profile_other.go - CPU model for tuning profile fingerprints elsewhere.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+
+//go:build !darwin
+
+package chacha20
+
+import "os"
+
+// cpuModel returns the CPU model from /proc/cpuinfo, or "" if there is no
+// /proc/cpuinfo, e.g. on Windows or the BSDs.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return parseCPUInfo(f)
+}
@@ -0,0 +1,87 @@
+// profile_test.go - test serializable tuning profiles.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTuningProfile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "chacha20.json")
+	p := NewTuningProfile(TuneParams{BlocksPerChunk: 120, MaxGoroutines: 40})
+	p.MinParallelSize = 9000
+	if err := SaveTuningProfile(name, p); err != nil {
+		t.Fatal(err)
+	}
+	// The embedded TuneParams' fields are saved at the top level.
+	b, err := os.ReadFile(name)
+	if err != nil || !strings.Contains(string(b), `"blocks_per_chunk": 120,`) ||
+		!strings.Contains(string(b), `"min_parallel_size": 9000,`) || strings.Contains(string(b), "Throughput") {
+		t.Errorf("SaveTuningProfile wrote %s, %v", b, err)
+	}
+	got, err := LoadTuningProfile(name)
+	if p.Fingerprint.CPUModel == "" {
+		// The CPU model is unknown here, so it can't be matched.
+		if !errors.Is(err, ErrFingerprintMismatch) {
+			t.Errorf("LoadTuningProfile without a CPU model: err = %v", err)
+		}
+	} else if err != nil {
+		t.Fatalf("LoadTuningProfile of this host's profile: %v", err)
+	}
+	if got != p {
+		t.Errorf("LoadTuningProfile = %+v, want %+v", got, p)
+	}
+
+	// A profile from another host loads, but with a warning.
+	p.Fingerprint.GOMAXPROCS++
+	p.Fingerprint.CPUModel = "other"
+	if err := SaveTuningProfile(name, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err = LoadTuningProfile(name)
+	if !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("LoadTuningProfile of another host's profile: err = %v", err)
+	}
+	if got != p {
+		t.Errorf("LoadTuningProfile with mismatch = %+v, want %+v", got, p)
+	}
+
+	if _, err = LoadTuningProfile(filepath.Join(t.TempDir(), "none.json")); err == nil {
+		t.Errorf("LoadTuningProfile of a missing file succeeded")
+	}
+}
+
+func TestParseCPUInfo(t *testing.T) {
+	tests := []struct {
+		cpuinfo, want string
+	}{
+		{"processor\t: 0\nvendor_id\t: GenuineIntel\nmodel\t\t: 85\n" +
+			"model name\t: Intel(R) Xeon(R) Processor\n\nprocessor\t: 1\n" +
+			"model name\t: Intel(R) Xeon(R) Processor\n",
+			"Intel(R) Xeon(R) Processor"},
+		{"processor\t: 0\nBogoMIPS\t: 243.75\nCPU implementer\t: 0x41\n" +
+			"CPU architecture: 8\nCPU variant\t: 0x3\nCPU part\t: 0xd0c\n" +
+			"\nprocessor\t: 1\nCPU implementer\t: 0x41\nCPU part\t: 0xd0c\n" +
+			"\nModel\t\t: Raspberry Pi 5 Model B Rev 1.0\n",
+			"CPU implementer 0x41 part 0xd0c"},
+		{"processor\t: 0\ncpu model\t: Loongson-3A5000\n", "Loongson-3A5000"},
+		{"processor\t: 0\n", ""},
+	}
+	for i := 0; i < len(tests); i++ {
+		if got := parseCPUInfo(strings.NewReader(tests[i].cpuinfo)); got != tests[i].want {
+			t.Errorf("parseCPUInfo test %d = %q, want %q", i, got, tests[i].want)
+		}
+	}
+
+	f := HostFingerprint()
+	f.CPUModel = ""
+	if f.diff(f) == "" {
+		t.Errorf("Fingerprint.diff matched an empty CPU model")
+	}
+}
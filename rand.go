@@ -0,0 +1,149 @@
+// rand.go - public domain global random number generator.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// A Ctx has no locking, so one shared by goroutines as a package-level
+// random number generator races.  RandReader and the functions here are
+// safe for concurrent use instead: they spread callers over shards, each a
+// DRBG seeded from the operating system and reseeded periodically.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A shard is reseeded from the operating system once it has produced
+// randReseedBytes bytes or randReseedInterval has passed, whichever is
+// first.  They are variables for testing.
+var (
+	randReseedBytes    uint64 = 1 << 28
+	randReseedInterval        = 5 * time.Minute
+)
+
+// randShard is one of the generators behind RandReader.
+type randShard struct {
+	mu     sync.Mutex
+	d      *DRBG
+	bytes  uint64    // produced since seeding
+	seeded time.Time // when last seeded
+}
+
+var (
+	randOnce   sync.Once
+	randShards []randShard
+	randNext   atomic.Uint32 // where the search for an idle shard starts
+)
+
+// RandReader is a global, shared source of cryptographically secure random
+// bytes, like crypto/rand.Reader but generated in-process.  It is safe for
+// concurrent use and scales across goroutines: there is a generator per
+// processor (runtime.GOMAXPROCS(0) when first used), each a DRBG seeded from
+// crypto/rand and reseeded after every 256 MiB it produces and every 5
+// minutes.  Its Read always returns len(p), nil.
+//
+// Go programs don't fork without exec, so a child process never shares
+// RandReader's state with its parent.
+var RandReader io.Reader = randReader{}
+
+type randReader struct{}
+
+func (randReader) Read(p []byte) (int, error) {
+	randRead(p)
+	return len(p), nil
+}
+
+// randRead fills p from an idle shard, or waits for one if all are busy.
+func randRead(p []byte) {
+	randOnce.Do(func() {
+		randShards = make([]randShard, runtime.GOMAXPROCS(0))
+	})
+	n := uint32(len(randShards))
+	start := randNext.Add(1)
+	var s *randShard
+	for i := uint32(0); i < n; i++ {
+		if t := &randShards[(start+i)%n]; t.mu.TryLock() {
+			s = t
+			break
+		}
+	}
+	if s == nil {
+		s = &randShards[start%n]
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+	if s.d == nil || s.bytes >= randReseedBytes || time.Since(s.seeded) >= randReseedInterval {
+		var seed [32]byte
+		if _, err := crand.Read(seed[:]); err != nil {
+			panic("chacha20.RandReader: crypto/rand failed: " + err.Error())
+		}
+		if s.d == nil {
+			s.d = NewDRBG(seed[:], 0)
+		} else {
+			s.d.Reseed(seed[:])
+		}
+		clear(seed[:])
+		s.bytes = 0
+		s.seeded = time.Now()
+	}
+	s.d.Read(p)
+	s.bytes += uint64(len(p))
+}
+
+// Bytes returns n random bytes from RandReader.
+func Bytes(n int) []byte {
+	b := make([]byte, n)
+	randRead(b)
+	return b
+}
+
+// Uint64 returns a random uint64 from RandReader.
+func Uint64() uint64 {
+	var b [8]byte
+	randRead(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// IntN returns a uniformly distributed random int in [0, n) from
+// RandReader.  It panics if n <= 0.
+func IntN(n int) int {
+	if n <= 0 {
+		panic("chacha20.IntN: n <= 0")
+	}
+	return int(uint64n(uint64(n)))
+}
+
+// uint64n returns a uniformly distributed random uint64 in [0, n), n > 0,
+// by Lemire's multiply-and-reject method.
+func uint64n(n uint64) uint64 {
+	hi, lo := bits.Mul64(Uint64(), n)
+	if lo < n {
+		thresh := -n % n
+		for lo < thresh {
+			hi, lo = bits.Mul64(Uint64(), n)
+		}
+	}
+	return hi
+}
+
+// Shuffle randomly permutes n elements with RandReader, calling swap to
+// exchange the elements with indexes i and j, as math/rand/v2's Shuffle
+// does.  It panics if n < 0.
+func Shuffle(n int, swap func(i, j int)) {
+	if n < 0 {
+		panic("chacha20.Shuffle: n < 0")
+	}
+	// Fisher-Yates shuffle.
+	for i := n - 1; i > 0; i-- {
+		swap(i, int(uint64n(uint64(i+1))))
+	}
+}
@@ -0,0 +1,116 @@
+// rand_test.go - test the global random number generator.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRandReader(t *testing.T) {
+	// Concurrent use; go test -race checks the locking.
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			b := make([]byte, n)
+			for j := 0; j < 50; j++ {
+				if k, err := RandReader.Read(b); k != n || err != nil {
+					t.Errorf("RandReader.Read = %d, %v", k, err)
+					return
+				}
+				Uint64()
+			}
+		}(i * 100)
+	}
+	wg.Wait()
+
+	a, b := Bytes(32), Bytes(32)
+	if len(a) != 32 || bytes.Equal(a, b) {
+		t.Errorf("Bytes returned %x and %x", a, b)
+	}
+
+	// Reseeding by bytes and by time.
+	savedBytes, savedInterval := randReseedBytes, randReseedInterval
+	defer func() { randReseedBytes, randReseedInterval = savedBytes, savedInterval }()
+	randReseedBytes = 100
+	for i := 0; i < len(randShards); i++ {
+		Bytes(60)
+		Bytes(60)
+	}
+	for i := 0; i < len(randShards); i++ {
+		if s := &randShards[i]; s.d != nil && s.bytes > 120 {
+			t.Errorf("shard %d produced %d bytes without reseeding", i, s.bytes)
+		}
+	}
+	randReseedBytes = savedBytes
+	randReseedInterval = 0
+	before := time.Now()
+	Bytes(1)
+	seeded := false
+	for i := 0; i < len(randShards); i++ {
+		seeded = seeded || !randShards[i].seeded.Before(before)
+	}
+	if !seeded {
+		t.Errorf("no shard reseeded after randReseedInterval")
+	}
+}
+
+func TestIntN(t *testing.T) {
+	var counts [7]int
+	for i := 0; i < 7000; i++ {
+		counts[IntN(7)]++
+	}
+	for i := 0; i < len(counts); i++ {
+		if counts[i] < 800 || counts[i] > 1200 {
+			t.Errorf("IntN(7) returned %d %d times of 7000", i, counts[i])
+		}
+	}
+	if n := IntN(1); n != 0 {
+		t.Errorf("IntN(1) = %d", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("IntN(0) didn't panic")
+		}
+	}()
+	IntN(0)
+}
+
+func TestShuffle(t *testing.T) {
+	s := make([]int, 100)
+	for i := 0; i < len(s); i++ {
+		s[i] = i
+	}
+	Shuffle(len(s), func(i, j int) { s[i], s[j] = s[j], s[i] })
+	seen := make([]bool, len(s))
+	moved := 0
+	for i := 0; i < len(s); i++ {
+		seen[s[i]] = true
+		if s[i] != i {
+			moved++
+		}
+	}
+	for i := 0; i < len(seen); i++ {
+		if !seen[i] {
+			t.Fatalf("Shuffle lost element %d", i)
+		}
+	}
+	if moved < 50 {
+		t.Errorf("Shuffle moved only %d of 100 elements", moved)
+	}
+	Shuffle(0, func(i, j int) { t.Errorf("Shuffle(0) called swap") })
+}
+
+func BenchmarkRand_Uint64(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			Uint64()
+		}
+	})
+}
@@ -0,0 +1,66 @@
+// seeker.go - public domain io.ReadSeeker over a ChaCha20 key stream.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// Ctx.Seek takes a block number, so Ctx itself can't implement io.Seeker,
+// whose Seek takes a byte offset and whence.  Seeker adapts it.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"errors"
+	"io"
+)
+
+// Seeker implements io.ReadSeeker (and io.Reader and io.Seeker) over the
+// byte offsets of a Ctx's key stream, using SeekByte and TellByte.  It reads
+// and moves the Ctx it was made from, so Encrypt, Decrypt and XORKeyStream
+// calls on that Ctx continue from where Seek put it.
+type Seeker struct {
+	x *Ctx
+}
+
+// NewSeeker returns a Seeker for x.
+func NewSeeker(x *Ctx) *Seeker {
+	return &Seeker{x: x}
+}
+
+// Read fills b with key stream bytes from the current offset, as x.Read
+// does.
+func (s *Seeker) Read(b []byte) (int, error) {
+	return s.x.Read(b)
+}
+
+// Seek sets the key stream byte offset for the next Read, or for the next
+// Encrypt or XORKeyStream call on the Seeker's Ctx, to offset relative to
+// whence and returns it.  io.SeekEnd is relative to the end of the key
+// stream, which is only expressible as an int64 for a context created with
+// NewIETF (256 GiB).  Seek returns an error, and doesn't move, if the new
+// offset would be negative, beyond the end of the key stream or beyond
+// the largest int64.
+func (s *Seeker) Seek(offset int64, whence int) (int64, error) {
+	var base uint64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = s.x.TellByte()
+	case io.SeekEnd:
+		if !s.x.ietf {
+			return 0, errors.New("chacha20.Seeker.Seek: the key stream end is beyond an int64 offset")
+		}
+		base = (s.x.maxCounter() + 1) * blockLen
+	default:
+		return 0, errors.New("chacha20.Seeker.Seek: invalid whence")
+	}
+	pos := base + uint64(offset) // two's complement adds negative offsets
+	if offset < 0 && pos > base || offset > 0 && pos < base || pos > 1<<63-1 {
+		return 0, errors.New("chacha20.Seeker.Seek: invalid offset")
+	}
+	if s.x.ietf && pos > (s.x.maxCounter()+1)*blockLen {
+		return 0, errors.New("chacha20.Seeker.Seek: offset beyond the end of the key stream")
+	}
+	s.x.SeekByte(pos)
+	return int64(pos), nil
+}
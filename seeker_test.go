@@ -0,0 +1,96 @@
+// seeker_test.go - test byte-granular seeking and the io.Seeker adapter.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSeekByte(t *testing.T) {
+	ref := make([]byte, 200_000)
+	NewSmallMemory(key, iv).Read(ref)
+
+	x := New(key, iv)
+	x.TuneParallel(25, 0) // parallel process from 3,200 bytes
+	offsets := []uint64{0, 1, 63, 64, 65, 1000, 4096 + 17, 50_001}
+	lengths := []int{1, 70, 9000, 100_000}
+	for i := 0; i < len(offsets); i++ {
+		for j := 0; j < len(lengths); j++ {
+			off, n := offsets[i], lengths[j]
+			x.SeekByte(off)
+			if got := x.TellByte(); got != off {
+				t.Errorf("TellByte after SeekByte(%d) = %d", off, got)
+			}
+			got := make([]byte, n)
+			x.Encrypt(got, got) // XOR of zeros: the key stream
+			if !bytes.Equal(got, ref[off:off+uint64(n)]) {
+				t.Errorf("Encrypt of %d bytes after SeekByte(%d) is wrong", n, off)
+			}
+			if tell := x.TellByte(); tell != off+uint64(n) {
+				t.Errorf("TellByte after SeekByte(%d) and %d bytes = %d", off, n, tell)
+			}
+		}
+	}
+
+	// The end of an IETF key stream.
+	ietf := NewIETF(key, make([]byte, 12))
+	ietf.Seek(0xffffffff)
+	last := make([]byte, blockLen)
+	ietf.Read(last)
+	if tell := ietf.TellByte(); tell != 1<<38 {
+		t.Errorf("IETF TellByte at end = %d, want %d", tell, uint64(1<<38))
+	}
+	ietf.SeekByte(1<<38 - 10)
+	got := make([]byte, 10)
+	if n, err := ietf.Read(got); n != 10 || err != io.EOF || !bytes.Equal(got, last[54:]) {
+		t.Errorf("IETF Read of last 10 bytes = %d, %v, %x; want 10, EOF, %x", n, err, got, last[54:])
+	}
+	ietf.SeekByte(1 << 38)
+	if tell := ietf.TellByte(); tell != 1<<38 {
+		t.Errorf("IETF TellByte after SeekByte(256 GiB) = %d", tell)
+	}
+	ietf.SetExhaustionPolicy(ExhaustError, nil)
+	if _, err := ietf.Read(got); err != ErrKeystreamExhausted {
+		t.Errorf("IETF Read after SeekByte(256 GiB): err = %v", err)
+	}
+}
+
+func TestSeeker(t *testing.T) {
+	ref := make([]byte, 1000)
+	NewSmallMemory(key, iv).Read(ref)
+
+	var s io.ReadSeeker = NewSeeker(New(key, iv))
+	check := func(offset int64, whence int, want int64) {
+		t.Helper()
+		pos, err := s.Seek(offset, whence)
+		if err != nil || pos != want {
+			t.Fatalf("Seek(%d, %d) = %d, %v; want %d", offset, whence, pos, err, want)
+		}
+		b := make([]byte, 5)
+		s.Read(b)
+		if !bytes.Equal(b, ref[want:want+5]) {
+			t.Errorf("Read after Seek(%d, %d) is wrong", offset, whence)
+		}
+	}
+	check(100, io.SeekStart, 100)
+	check(-50, io.SeekCurrent, 55)  // after reading 5 bytes at 100
+	check(200, io.SeekCurrent, 260) // after reading 5 bytes at 55
+	if _, err := s.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Seek to a negative offset succeeded")
+	}
+	if _, err := s.Seek(0, io.SeekEnd); err == nil {
+		t.Errorf("SeekEnd on a 64-bit counter key stream succeeded")
+	}
+
+	ietf := NewSeeker(NewIETF(key, make([]byte, 12)))
+	if pos, err := ietf.Seek(-64, io.SeekEnd); pos != 1<<38-64 || err != nil {
+		t.Errorf("IETF Seek(-64, SeekEnd) = %d, %v", pos, err)
+	}
+	if _, err := ietf.Seek(1, io.SeekEnd); err == nil {
+		t.Errorf("IETF Seek beyond the end succeeded")
+	}
+}
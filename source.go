@@ -0,0 +1,99 @@
+// source.go - public domain math/rand/v2 Source from a ChaCha key stream.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// Source has the Uint64 method of math/rand/v2's Source interface, so
+// rand.New(chacha20.NewSource(seed, 20)) is a reproducible generator whose
+// output is a ChaCha key stream.  This file doesn't import math/rand/v2,
+// so the package still builds with Go versions that lack it.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// sourceBufLen is how many key stream bytes a Source generates at a time.
+const sourceBufLen = 16 * blockLen
+
+// Source is a source of pseudorandom uint64 values for math/rand/v2, made
+// from the ChaCha key stream of a 32-byte seed used as the key, with a zero
+// iv.  The same seed and rounds give the same values on every platform:
+// each Uint64 is the next 8 key stream bytes in little-endian order.
+// Key stream is generated a buffer at a time, so Uint64 is cheap.
+//
+// A Source is not safe for concurrent use.  Use NewSource to allocate one;
+// the zero Source is usable only as the receiver of UnmarshalBinary.
+type Source struct {
+	x   *Ctx
+	buf [sourceBufLen]byte
+	n   int // buf[n:] is unused key stream
+}
+
+// NewSource returns a Source seeded with seed that uses ChaCha with rounds
+// rounds, which must be 8, 12 or 20; NewSource panics otherwise.
+func NewSource(seed [32]byte, rounds int) *Source {
+	s := &Source{}
+	s.init(seed[:], rounds)
+	return s
+}
+
+// init sets s up to generate the key stream of seed from its beginning.
+func (s *Source) init(seed []byte, rounds int) {
+	s.x = NewSmallMemory(seed, make([]byte, 8))
+	s.x.SetRounds(rounds)
+	s.n = len(s.buf)
+}
+
+// Uint64 returns the next pseudorandom uint64.
+func (s *Source) Uint64() uint64 {
+	if s.n > len(s.buf)-8 {
+		s.x.Keystream(s.buf[:])
+		s.n = 0
+	}
+	v := binary.LittleEndian.Uint64(s.buf[s.n:])
+	s.n += 8
+	return v
+}
+
+// Source state format, version 1: magic "ccsr", version 1, rounds, the
+// 32-byte seed and the little-endian uint64 key stream offset of the next
+// value.
+const (
+	sourceMagic    = "ccsr"
+	sourceVersion  = 1
+	sourceStateLen = 4 + 1 + 1 + 32 + 8
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler.  The state includes
+// the seed, so protect it as you would the seed.
+func (s *Source) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, sourceStateLen)
+	b = append(b, sourceMagic...)
+	b = append(b, sourceVersion, byte(s.x.rounds))
+	for i := 4; i < 12; i++ {
+		b = binary.LittleEndian.AppendUint32(b, s.x.input[i])
+	}
+	return binary.LittleEndian.AppendUint64(b, s.x.TellByte()-uint64(len(s.buf)-s.n)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  It restores a
+// state from MarshalBinary, after which s returns the same values the
+// marshaled Source would have.
+func (s *Source) UnmarshalBinary(data []byte) error {
+	if len(data) != sourceStateLen || string(data[:4]) != sourceMagic {
+		return errors.New("chacha20.Source.UnmarshalBinary: invalid state")
+	}
+	if data[4] != sourceVersion {
+		return errors.New("chacha20.Source.UnmarshalBinary: unsupported state version")
+	}
+	if !validRounds(int(data[5])) {
+		return ErrInvalidRounds
+	}
+	s.init(data[6:38], int(data[5]))
+	s.x.SeekByte(binary.LittleEndian.Uint64(data[38:]))
+	return nil
+}
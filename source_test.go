@@ -0,0 +1,83 @@
+// source_test.go - test the math/rand/v2 Source.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+//go:build go1.22
+
+package chacha20
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+var _ rand.Source = &Source{}
+
+func TestSource(t *testing.T) {
+	var seed [32]byte
+	for i := 0; i < len(seed); i++ {
+		seed[i] = byte(i)
+	}
+
+	// Values 0, 1, 128 and 255 are key stream bytes 0, 8, 1024 and 2040,
+	// as computed by golang.org/x/crypto/chacha20 with a zero nonce.
+	want := map[int]uint64{
+		0:   0x6a19c5d97d2bfd39,
+		1:   0x494adcb87703bd8d,
+		128: 0x8bd54256d13b1a36,
+		255: 0x7343d61fb3c443b8,
+	}
+	s := NewSource(seed, 20)
+	for i := 0; i < 256; i++ {
+		v := s.Uint64()
+		if w, ok := want[i]; ok && v != w {
+			t.Errorf("Uint64 number %d = %#x, want %#x", i, v, w)
+		}
+	}
+
+	// The same seed and rounds give the same sequence; other rounds don't.
+	r1, r2 := rand.New(NewSource(seed, 8)), rand.New(NewSource(seed, 8))
+	for i := 0; i < 1000; i++ {
+		if a, b := r1.IntN(1000), r2.IntN(1000); a != b {
+			t.Fatalf("IntN number %d differs: %d and %d", i, a, b)
+		}
+	}
+	if NewSource(seed, 8).Uint64() == NewSource(seed, 20).Uint64() {
+		t.Errorf("8 and 20 rounds give the same first value")
+	}
+
+	// A restored Source continues the sequence, within a buffer or not.
+	s = NewSource(seed, 8)
+	for i := 0; i < 77; i++ {
+		s.Uint64()
+	}
+	state, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Source
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if a, b := s.Uint64(), restored.Uint64(); a != b {
+			t.Fatalf("restored Source value %d = %#x, want %#x", i, b, a)
+		}
+	}
+
+	state[5] = 7 // rounds
+	if err := restored.UnmarshalBinary(state); err == nil {
+		t.Errorf("UnmarshalBinary with 7 rounds succeeded")
+	}
+	if err := restored.UnmarshalBinary(state[:10]); err == nil {
+		t.Errorf("UnmarshalBinary of a short state succeeded")
+	}
+}
+
+func BenchmarkSource_Uint64(b *testing.B) {
+	s := NewSource([32]byte{}, 20)
+	b.SetBytes(8)
+	for i := 0; i < b.N; i++ {
+		s.Uint64()
+	}
+}
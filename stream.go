@@ -0,0 +1,336 @@
+// stream.go - public domain buffered io.Reader and io.Writer adapters.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// crypto/cipher.StreamReader and StreamWriter call XORKeyStream with
+// whatever buffer their caller passes, typically 32 KiB from io.Copy, which
+// is too short for parallel processing.  Reader and Writer instead decrypt
+// and encrypt in buffers long enough to be parallel processed, and
+// implement io.WriterTo and io.ReaderFrom so io.Copy uses those buffers.
+// The buffers come from a sync.Pool and are held only while they hold
+// data.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// streamBufLen is the length of the pooled buffers of Reader and Writer.
+const streamBufLen = 1 << 20
+
+var streamBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, streamBufLen)
+		return &b
+	},
+}
+
+// streamBufSize returns the buffer length Reader and Writer use with x: at
+// least streamBufLen, and long enough for x to parallel process.
+func streamBufSize(x *Ctx) int {
+	return max(streamBufLen, x.minParallel, 3*x.blocksPerChunk*blockLen)
+}
+
+// getStreamBuf returns a buffer of streamBufSize(x) bytes.
+func getStreamBuf(x *Ctx) []byte {
+	if size := streamBufSize(x); size > streamBufLen {
+		return make([]byte, size)
+	}
+	return *streamBufPool.Get().(*[]byte)
+}
+
+// putStreamBuf zeroes b, which may hold plaintext, and returns it to the
+// pool if it came from there.
+func putStreamBuf(b []byte) {
+	clear(b)
+	if len(b) == streamBufLen {
+		streamBufPool.Put(&b)
+	}
+}
+
+// xorInPlace XORs b with x's key stream.  It returns how many bytes of b
+// were XORed, and ErrKeystreamExhausted, or the error from x's
+// ExhaustionPolicy, if not all of them were.
+func xorInPlace(x *Ctx, b []byte) (int, error) {
+	n, err := x.Encrypt(b, b)
+	if n == len(b) {
+		return n, nil
+	}
+	if err == io.EOF {
+		err = ErrKeystreamExhausted
+	}
+	return n, err
+}
+
+// fill reads from r until b is full, r ends or r fails, so that readers
+// that return short reads, e.g. network connections, still give WriteTo
+// buffers long enough to parallel process.  It returns io.EOF, not
+// io.ErrUnexpectedEOF, when r ends with b partly filled.
+func fill(r io.Reader, b []byte) (int, error) {
+	n, err := io.ReadFull(r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Reader decrypts what it reads from an io.Reader.  It implements
+// io.Reader and io.WriterTo.  A Reader is not safe for concurrent use.
+type Reader struct {
+	r      io.Reader
+	x      *Ctx
+	buf    []byte // decrypted buf[rd:wr] not yet returned; nil when empty
+	rd, wr int
+	err    error // from r or x, returned after buf's contents
+}
+
+// NewReader returns a Reader that decrypts the bytes read from r with a
+// context from New(key, iv).  NewReader panics, as New does, if key or iv
+// has an invalid length.
+func NewReader(r io.Reader, key, iv []byte) *Reader {
+	return NewReaderCtx(r, New(key, iv))
+}
+
+// NewReaderCtx is like NewReader but decrypts with x, e.g. a context from
+// NewIETF or NewX, from x's current position.  The Reader advances x, which
+// must not be used otherwise while the Reader is.
+func NewReaderCtx(r io.Reader, x *Ctx) *Reader {
+	return &Reader{r: r, x: x}
+}
+
+// Read reads and decrypts up to len(p) bytes into p.  It reads from the
+// underlying io.Reader at most once per call, into a buffer long enough for
+// parallel processing, or straight into p if p is at least as long, and
+// returns what that read gave rather than wait for more.  At the end of
+// the underlying reader Read returns 0, io.EOF.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.rd == r.wr {
+		r.release()
+		if r.err != nil {
+			return 0, r.readErr()
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if len(p) >= streamBufSize(r.x) {
+			n, err = r.r.Read(p)
+			k, xerr := xorInPlace(r.x, p[:n])
+			if xerr != nil {
+				return k, xerr
+			}
+			return n, err
+		}
+		r.buf = getStreamBuf(r.x)
+		n, r.err = r.r.Read(r.buf)
+		k, xerr := xorInPlace(r.x, r.buf[:n])
+		if xerr != nil {
+			r.err = xerr
+		}
+		r.rd, r.wr = 0, k
+		if k == 0 {
+			r.release()
+			return 0, r.readErr()
+		}
+	}
+	n = copy(p, r.buf[r.rd:r.wr])
+	r.rd += n
+	if r.rd == r.wr {
+		r.release()
+	}
+	return n, nil
+}
+
+// WriteTo decrypts everything from the underlying io.Reader to w, in
+// parallel processed lengths, until io.EOF or an error.  It returns the
+// number of bytes written to w and the first error other than io.EOF.
+// io.Copy calls WriteTo.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.rd < r.wr {
+		k, werr := w.Write(r.buf[r.rd:r.wr])
+		r.rd += k
+		n += int64(k)
+		if werr == nil && r.rd < r.wr {
+			werr = io.ErrShortWrite
+		}
+		if werr != nil {
+			return n, werr
+		}
+	}
+	r.release()
+	if r.err != nil {
+		if err = r.readErr(); err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+	buf := getStreamBuf(r.x)
+	defer putStreamBuf(buf)
+	for {
+		k, rerr := fill(r.r, buf)
+		k, xerr := xorInPlace(r.x, buf[:k])
+		if k > 0 {
+			m, werr := w.Write(buf[:k])
+			n += int64(m)
+			if werr == nil && m < k {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if xerr != nil {
+			return n, xerr
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// release returns r's emptied buffer to the pool.
+func (r *Reader) release() {
+	if r.buf != nil {
+		putStreamBuf(r.buf)
+		r.buf = nil
+		r.rd, r.wr = 0, 0
+	}
+}
+
+// readErr returns and clears r.err.
+func (r *Reader) readErr() error {
+	err := r.err
+	r.err = nil
+	return err
+}
+
+// errWriterClosed is returned by Writer methods called after Close.
+var errWriterClosed = errors.New("chacha20.Writer: write after Close")
+
+// Writer encrypts what is written to it and writes it to an io.Writer.
+// Writes are buffered, so that they are encrypted with parallel processing
+// however short they are; call Flush or Close to write out the last of
+// them.  Writer implements io.WriteCloser and io.ReaderFrom.  A Writer is
+// not safe for concurrent use.
+type Writer struct {
+	w   io.Writer
+	x   *Ctx
+	buf []byte // plaintext buf[:n] not yet written; nil when empty
+	n   int
+	err error // sticky error
+}
+
+// NewWriter returns a Writer that encrypts with a context from
+// New(key, iv) and writes to w.  NewWriter panics, as New does, if key or
+// iv has an invalid length.
+func NewWriter(w io.Writer, key, iv []byte) *Writer {
+	return NewWriterCtx(w, New(key, iv))
+}
+
+// NewWriterCtx is like NewWriter but encrypts with x, e.g. a context from
+// NewIETF or NewX, from x's current position.  The Writer advances x, which
+// must not be used otherwise while the Writer is.
+func NewWriterCtx(w io.Writer, x *Ctx) *Writer {
+	return &Writer{w: w, x: x}
+}
+
+// Write buffers p for encryption, encrypting and writing the buffer
+// whenever it fills.  It returns the number of bytes of p accepted.  p is
+// not modified.  Once an error occurs every later Write, ReadFrom and Flush
+// returns it.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for n < len(p) && w.err == nil {
+		if w.buf == nil {
+			w.buf = getStreamBuf(w.x)
+		}
+		k := copy(w.buf[w.n:], p[n:])
+		w.n += k
+		n += k
+		if w.n == len(w.buf) && w.flush() != nil {
+			n -= k
+		}
+	}
+	return n, w.err
+}
+
+// ReadFrom reads from r until io.EOF or an error, encrypting and writing
+// each full buffer.  Less than a full buffer is left for a later Write,
+// Flush or Close.  It returns the number of bytes read from r and the first
+// error other than io.EOF.  io.Copy calls ReadFrom.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	for w.err == nil {
+		if w.buf == nil {
+			w.buf = getStreamBuf(w.x)
+		}
+		if w.n == len(w.buf) && w.flush() != nil {
+			break
+		}
+		k, rerr := r.Read(w.buf[w.n:])
+		w.n += k
+		n += int64(k)
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, w.err
+}
+
+// Flush encrypts and writes any buffered data to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	if w.flush() == nil && w.buf != nil {
+		putStreamBuf(w.buf)
+		w.buf = nil
+	}
+	return w.err
+}
+
+// flush encrypts and writes w.buf[:w.n], setting w.err if that fails.
+func (w *Writer) flush() error {
+	if w.err != nil || w.n == 0 {
+		return w.err
+	}
+	if _, w.err = xorInPlace(w.x, w.buf[:w.n]); w.err != nil {
+		return w.err
+	}
+	k, err := w.w.Write(w.buf[:w.n])
+	if err == nil && k < w.n {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.n = 0
+	return nil
+}
+
+// Close flushes w, then closes the underlying io.Writer if it is an
+// io.Closer.  It returns the first error from either.  Later calls to
+// Write, ReadFrom, Flush and Close return an error.
+func (w *Writer) Close() error {
+	if w.err == errWriterClosed {
+		return w.err
+	}
+	err := w.Flush()
+	if w.buf != nil {
+		putStreamBuf(w.buf)
+		w.buf = nil
+	}
+	if c, ok := w.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	w.err = errWriterClosed
+	return err
+}
@@ -0,0 +1,206 @@
+// stream_test.go - test the buffered io.Reader and io.Writer adapters.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	plain := make([]byte, 3*streamBufLen+12345)
+	crand.Read(plain)
+	enc := make([]byte, len(plain))
+	NewSmallMemory(key, iv).Encrypt(plain, enc)
+
+	if err := iotest.TestReader(NewReader(bytes.NewReader(enc), key, iv), plain); err != nil {
+		t.Error(err)
+	}
+
+	// Small reads, a read longer than the buffer, then io.Copy (WriteTo).
+	r := NewReader(iotest.HalfReader(bytes.NewReader(enc)), key, iv)
+	var got []byte
+	p := make([]byte, 1000)
+	for i := 0; i < 5; i++ {
+		n, err := r.Read(p[:i*100+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p[:n]...)
+	}
+	p = make([]byte, 2*streamBufLen+1)
+	n, err := io.ReadFull(r, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, p[:n]...)
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, out.Bytes()...)
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Reader output differs from the plaintext")
+	}
+
+	// Errors from the underlying reader come after the data before them.
+	failure := errors.New("failure")
+	r = NewReader(io.MultiReader(bytes.NewReader(enc[:100]), iotest.ErrReader(failure)), key, iv)
+	got, err = io.ReadAll(r)
+	if err != failure || !bytes.Equal(got, plain[:100]) {
+		t.Errorf("ReadAll with failing reader = %d bytes, %v", len(got), err)
+	}
+}
+
+// writeSizes records the length of each Write.
+type writeSizes struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (w *writeSizes) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestReaderShortReads(t *testing.T) {
+	plain := make([]byte, 2*streamBufLen+777)
+	crand.Read(plain)
+	enc := make([]byte, len(plain))
+	NewSmallMemory(key, iv).Encrypt(plain, enc)
+
+	// Short reads from the underlying reader still fill whole buffers, so
+	// WriteTo writes, and decrypts, parallel processed lengths.
+	w := &writeSizes{}
+	if _, err := NewReader(iotest.OneByteReader(bytes.NewReader(enc)), key, iv).WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Bytes(), plain) {
+		t.Errorf("WriteTo with one-byte reads differs from the plaintext")
+	}
+	want := []int{streamBufLen, streamBufLen, 777}
+	if len(w.sizes) != len(want) || w.sizes[0] != want[0] || w.sizes[1] != want[1] || w.sizes[2] != want[2] {
+		t.Errorf("WriteTo with one-byte reads wrote %d times, first %v, want %v",
+			len(w.sizes), w.sizes[:min(len(w.sizes), 3)], want)
+	}
+
+	// Read returns what is available instead of waiting for a full buffer,
+	// so request/response protocols don't deadlock.
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go c1.Write(enc[:100])
+	r := NewReader(c2, key, iv)
+	p := make([]byte, 1000)
+	c2.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if n, err := r.Read(p); n != 100 || err != nil || !bytes.Equal(p[:n], plain[:100]) {
+		t.Errorf("Read of 100 available bytes = %d, %v", n, err)
+	}
+
+	r = NewReader(iotest.OneByteReader(bytes.NewReader(enc[:50])), key, iv)
+	if n, err := r.Read(p); n != 1 || err != nil {
+		t.Errorf("Read with one-byte reads = %d, %v, want 1, nil", n, err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(append(p[:1:1], got...), plain[:50]) {
+		t.Errorf("Read with one-byte reads differs from the plaintext: %v", err)
+	}
+	if n, err := r.Read(p); n != 0 || err != io.EOF {
+		t.Errorf("Read at end = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+// writeCloser records whether it was closed.
+type writeCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *writeCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	plain := make([]byte, 3*streamBufLen+12345)
+	crand.Read(plain)
+	want := make([]byte, len(plain))
+	NewSmallMemory(key, iv).Encrypt(plain, want)
+
+	// Small writes are buffered until Flush.
+	var out writeCloser
+	w := NewWriter(&out, key, iv)
+	saved := bytes.Clone(plain)
+	w.Write(plain[:10])
+	w.Write(plain[10:1000])
+	if out.Len() != 0 {
+		t.Errorf("short writes were not buffered")
+	}
+	if err := w.Flush(); err != nil || !bytes.Equal(out.Bytes(), want[:1000]) {
+		t.Fatalf("Flush = %v, or wrong output", err)
+	}
+	if n, err := w.Write(plain[1000 : 2*streamBufLen]); n != 2*streamBufLen-1000 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(plain[2*streamBufLen:])); err != nil { // ReadFrom
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, saved) {
+		t.Errorf("Write modified its argument")
+	}
+	if err := w.Close(); err != nil || !out.closed {
+		t.Errorf("Close = %v, closed %v", err, out.closed)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("Writer output differs from Encrypt")
+	}
+	if _, err := w.Write(plain[:1]); err == nil {
+		t.Errorf("Write after Close succeeded")
+	}
+
+	// A failed write is reported, and sticks.
+	w = NewWriter(failWriter{}, key, iv)
+	w.Write(plain[:10])
+	if err := w.Flush(); err == nil {
+		t.Errorf("Flush to a failing io.Writer succeeded")
+	}
+	if n, err := w.Write(plain[:10]); n != 0 || err == nil {
+		t.Errorf("Write after failure = %d, %v", n, err)
+	}
+}
+
+// failWriter is an io.Writer that always fails.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failure")
+}
+
+func benchmarkCopy(b *testing.B, wrap func(io.Reader) io.Reader) {
+	m := make([]byte, 64<<20)
+	b.SetBytes(int64(len(m)))
+	for i := 0; i < b.N; i++ {
+		io.Copy(io.Discard, wrap(bytes.NewReader(m)))
+	}
+}
+
+func BenchmarkCopy_StreamReader(b *testing.B) {
+	benchmarkCopy(b, func(r io.Reader) io.Reader {
+		return &cipher.StreamReader{S: New(key, iv), R: r}
+	})
+}
+
+func BenchmarkCopy_Reader(b *testing.B) {
+	benchmarkCopy(b, func(r io.Reader) io.Reader {
+		return NewReader(r, key, iv)
+	})
+}
@@ -0,0 +1,165 @@
+// tune.go - public domain self-calibration of parallel processing.
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// AutoTune runs, in-process, a blocks-per-chunk and goroutine limit sweep
+// like the one cmd/chacha20bench does by hand, and returns the best
+// parameters for the host it runs on.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import (
+	crand "crypto/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// TuneParams holds parallel processing parameters as chosen by AutoTune
+// or loaded in a TuningProfile.  Apply them to a Ctx with
+// x.TuneParallel(p.BlocksPerChunk, p.MaxGoroutines), or to every Ctx
+// allocated afterwards with SetDefaultTuneParams.
+type TuneParams struct {
+	BlocksPerChunk int `json:"blocks_per_chunk"` // ChaCha blocks processed by each goroutine
+	MaxGoroutines  int `json:"max_goroutines"`   // goroutines that can run simultaneously
+
+	// MinParallelSize, if > 0, is the fewest bytes Encrypt processes in
+	// parallel; otherwise it processes more than 2 chunks in parallel.
+	MinParallelSize int `json:"min_parallel_size,omitempty"`
+
+	// SerialCutoff disables parallel processing for each Ctx allocated
+	// while GOMAXPROCS is at most SerialCutoff, because on that few
+	// processors parallel processing is slower than serial processing.
+	// Zero never disables it.
+	SerialCutoff int `json:"serial_cutoff,omitempty"`
+
+	GOMAXPROCS int     `json:"-"` // runtime.GOMAXPROCS(0) when measured
+	Throughput float64 `json:"-"` // bytes per second measured with these parameters
+}
+
+// Candidates AutoTune measures.  The blocks-per-chunk values are those the
+// hand-run tuning program that preceded cmd/chacha20bench measured.
+var tuneBlocksPerChunk = []int{25, 50, 100, 150, 200, 250, 300, 400, 500, 600}
+
+// tuneTolerance is how much slower than the fastest candidate AutoTune
+// accepts in return for a smaller chunk or goroutine limit, i.e. a lower
+// minimum parallel message size or less memory.  It also absorbs
+// measurement noise.
+const tuneTolerance = 0.03
+
+// AutoTune measures Encrypt's parallel processing speed on the current
+// host and GOMAXPROCS with several blocks-per-chunk and goroutine limit
+// combinations, spending about budget in total, and returns the best one.
+// Among combinations within 3% of the fastest it prefers the smallest
+// blocks-per-chunk, then the smallest goroutine limit.
+//
+// AutoTune doesn't change any Ctx or the package defaults; pass its result
+// to TuneParallel or SetDefaultTuneParams for that.  Run it when the host
+// is otherwise idle; other load skews the measurements.  Each combination
+// is measured at least once however small budget is.
+func AutoTune(budget time.Duration) TuneParams {
+	procs := runtime.GOMAXPROCS(0)
+	goroutines := tuneGoroutineCandidates(procs)
+
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	crand.Read(key)
+	crand.Read(iv)
+	x := New(key, iv)
+	x.UseParallel(true) // regardless of the package defaults
+	x.minParallel = 0
+
+	// The message is long enough for every candidate to use several
+	// chunks per goroutine of the largest limit that matters, procs.
+	maxChunk := tuneBlocksPerChunk[len(tuneBlocksPerChunk)-1] * blockLen
+	size := max(4<<20, 4*procs*maxChunk)
+	m := make([]byte, size)
+
+	perCandidate := budget / time.Duration(len(tuneBlocksPerChunk)*len(goroutines))
+	var results []TuneParams
+	best := 0.0
+	// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+	for i := 0; i < len(tuneBlocksPerChunk); i++ {
+		for j := 0; j < len(goroutines); j++ {
+			x.TuneParallel(tuneBlocksPerChunk[i], goroutines[j])
+			x.Encrypt(m, m) // warm up
+			calls := 0
+			start := time.Now()
+			elapsed := time.Duration(0)
+			for calls == 0 || elapsed < perCandidate {
+				x.Encrypt(m, m)
+				calls++
+				elapsed = time.Since(start)
+			}
+			p := TuneParams{
+				BlocksPerChunk: tuneBlocksPerChunk[i],
+				MaxGoroutines:  goroutines[j],
+				GOMAXPROCS:     procs,
+				Throughput:     float64(calls*size) / max(elapsed.Seconds(), 1e-9),
+			}
+			results = append(results, p)
+			best = max(best, p.Throughput)
+		}
+	}
+
+	// results is ordered by blocks-per-chunk, then goroutine limit, so
+	// the first result close enough to the best is the preferred one.
+	for i := 0; i < len(results); i++ {
+		if results[i].Throughput >= best*(1-tuneTolerance) {
+			return results[i]
+		}
+	}
+	return results[len(results)-1] // not reached
+}
+
+// tuneGoroutineCandidates returns the goroutine limits AutoTune measures
+// for procs processors, in increasing order.
+func tuneGoroutineCandidates(procs int) []int {
+	candidates := []int{procs, 4 * procs, 16 * procs, maxGoroutines}
+	var c []int
+	for i := 0; i < len(candidates); i++ {
+		if len(c) == 0 || candidates[i] > c[len(c)-1] {
+			c = append(c, candidates[i])
+		}
+	}
+	return c
+}
+
+// The package defaults for a new Ctx.
+var (
+	defaultTuneMu sync.Mutex
+	defaultTuning = TuneParams{
+		BlocksPerChunk: blocksPerChunk,
+		MaxGoroutines:  maxGoroutines,
+	}
+)
+
+// SetDefaultTuneParams sets the parallel processing parameters that New,
+// NewIETF, NewX and the other constructors give each Ctx they allocate
+// afterwards, e.g. from AutoTune or a TuningProfile's TuneParams.  Existing
+// Ctx values are not changed.  As with TuneParallel, BlocksPerChunk or
+// MaxGoroutines that is zero leaves its current default unchanged;
+// MinParallelSize and SerialCutoff are always set.  SetDefaultTuneParams
+// is safe for concurrent use.
+func SetDefaultTuneParams(p TuneParams) {
+	defaultTuneMu.Lock()
+	defer defaultTuneMu.Unlock()
+	if p.BlocksPerChunk > 0 {
+		defaultTuning.BlocksPerChunk = p.BlocksPerChunk
+	}
+	if p.MaxGoroutines > 0 {
+		defaultTuning.MaxGoroutines = p.MaxGoroutines
+	}
+	defaultTuning.MinParallelSize = max(p.MinParallelSize, 0)
+	defaultTuning.SerialCutoff = max(p.SerialCutoff, 0)
+}
+
+// DefaultTuneParams returns the current package default parallel
+// processing parameters.  GOMAXPROCS and Throughput are zero.
+func DefaultTuneParams() TuneParams {
+	defaultTuneMu.Lock()
+	defer defaultTuneMu.Unlock()
+	return defaultTuning
+}
@@ -0,0 +1,80 @@
+// tune_test.go - test self-calibration of parallel processing.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAutoTune(t *testing.T) {
+	if testing.Short() {
+		t.Skip("AutoTune measures for a while; skipped in short mode")
+	}
+	p := AutoTune(time.Millisecond)
+	if p.BlocksPerChunk <= 0 || p.MaxGoroutines <= 0 || p.Throughput <= 0 {
+		t.Fatalf("AutoTune returned %+v", p)
+	}
+	if p.GOMAXPROCS != runtime.GOMAXPROCS(0) {
+		t.Errorf("AutoTune GOMAXPROCS = %d, want %d", p.GOMAXPROCS, runtime.GOMAXPROCS(0))
+	}
+
+	// The parameters apply to a Ctx through TuneParallel without changing
+	// its output.
+	m := make([]byte, 5*p.BlocksPerChunk*blockLen+17)
+	want := make([]byte, len(m))
+	NewSmallMemory(key, iv).Encrypt(m, want)
+	x := New(key, iv)
+	x.TuneParallel(p.BlocksPerChunk, p.MaxGoroutines)
+	got := make([]byte, len(m))
+	x.Encrypt(m, got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Encrypt with AutoTune parameters differs from serial Encrypt")
+	}
+}
+
+func TestSetDefaultTuneParams(t *testing.T) {
+	saved := DefaultTuneParams()
+	defer SetDefaultTuneParams(saved)
+
+	SetDefaultTuneParams(TuneParams{BlocksPerChunk: 75})
+	d := DefaultTuneParams()
+	if d.BlocksPerChunk != 75 || d.MaxGoroutines != saved.MaxGoroutines {
+		t.Fatalf("DefaultTuneParams = %+v after setting BlocksPerChunk only", d)
+	}
+	SetDefaultTuneParams(TuneParams{MaxGoroutines: 7})
+	x := New(key, iv)
+	if x.blocksPerChunk != 75 || x.goroutinesMax != 7 || cap(x.guard) != 7 {
+		t.Errorf("New got blocksPerChunk %d, goroutinesMax %d, guard %d; want 75, 7, 7",
+			x.blocksPerChunk, x.goroutinesMax, cap(x.guard))
+	}
+
+	// A minimum parallel size below 2 chunks makes shorter messages
+	// parallel processed, with the same result.
+	SetDefaultTuneParams(TuneParams{BlocksPerChunk: 50, MinParallelSize: 50 * blockLen})
+	x = New(key, iv)
+	if x.blocksPerChunk != 50 || x.goroutinesMax != 7 || x.minParallel != 50*blockLen {
+		t.Fatalf("New after SetDefaultTuneParams: blocksPerChunk %d, goroutinesMax %d, minParallel %d",
+			x.blocksPerChunk, x.goroutinesMax, x.minParallel)
+	}
+	if !x.parallelWorth(60*blockLen, 50*blockLen) || x.parallelWorth(40*blockLen, 50*blockLen) {
+		t.Errorf("parallelWorth ignores MinParallelSize")
+	}
+	m := make([]byte, 75*blockLen+3)
+	want := make([]byte, len(m))
+	NewSmallMemory(key, iv).Encrypt(m, want)
+	got := make([]byte, len(m))
+	x.Encrypt(m, got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Encrypt with MinParallelSize differs from serial Encrypt")
+	}
+
+	SetDefaultTuneParams(TuneParams{SerialCutoff: runtime.GOMAXPROCS(0)})
+	if New(key, iv).parallel {
+		t.Errorf("New made a parallel Ctx with GOMAXPROCS <= SerialCutoff")
+	}
+}
@@ -0,0 +1,91 @@
+// xchacha20.go - public domain XChaCha20 (extended-nonce ChaCha20).
+// Public domain is per <https://creativecommons.org/publicdomain/zero/1.0/>
+//
+// See https://datatracker.ietf.org/doc/html/draft-irtf-cfrg-xchacha
+// for a description of HChaCha20 and XChaCha20.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+////
+
+package chacha20
+
+import "encoding/binary"
+
+// HChaCha20 derives a 32-byte subkey from a 32-byte key and the first
+// 16 bytes of a nonce, as described in draft-irtf-cfrg-xchacha.  It is the
+// building block of XChaCha20 (see NewX).  HChaCha20 always uses 20 rounds.
+// HChaCha20 panics with ErrInvalidKeySize if len(key) is not 32 or with
+// ErrInvalidNonceSize if len(nonce) is not 16; use HChaCha20WithError when
+// key or nonce comes from untrusted input.
+func HChaCha20(key, nonce []byte) (subkey []byte) {
+	subkey, err := HChaCha20WithError(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// HChaCha20WithError is like HChaCha20 but returns ErrInvalidKeySize or
+// ErrInvalidNonceSize instead of panicking when key or nonce has an
+// invalid length.
+func HChaCha20WithError(key, nonce []byte) (subkey []byte, err error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	if len(nonce) != 16 {
+		return nil, ErrInvalidNonceSize
+	}
+	var x Ctx
+	var out [blockLen]byte
+	x.KeySetup(key)
+	x.input[12] = binary.LittleEndian.Uint32(nonce[0:])
+	x.input[13] = binary.LittleEndian.Uint32(nonce[4:])
+	x.input[14] = binary.LittleEndian.Uint32(nonce[8:])
+	x.input[15] = binary.LittleEndian.Uint32(nonce[12:])
+	salsa20_wordtobyte(x.input[:], defaultRounds, out[:])
+
+	// salsa20_wordtobyte adds the input words to the permuted state as its
+	// last step.  HChaCha20 has no such feed-forward, so subtract the
+	// input back out of the eight words that make up the subkey.
+	subkey = make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(subkey[4*i:],
+			binary.LittleEndian.Uint32(out[4*i:])-x.input[i])
+		binary.LittleEndian.PutUint32(subkey[16+4*i:],
+			binary.LittleEndian.Uint32(out[48+4*i:])-x.input[12+i])
+	}
+	return
+}
+
+// NewX allocates a new XChaCha20 context given a 32-byte key and a 24-byte
+// nonce.  A 24-byte nonce is long enough to be chosen at random, e.g. with
+// crypto/rand, without a practical risk of reuse.  NewX panics if len(key)
+// is not 32 or len(nonce) is not 24.
+//
+// The returned context is an ordinary Ctx keyed with
+// HChaCha20(key, nonce[:16]) and using nonce[16:] as its 8-byte iv, so
+// SetRounds, Seek, parallel processing and all other methods work as they
+// do for New.  The subkey is always derived with 20 rounds; SetRounds
+// changes only the rounds used to produce the key stream.  The key stream
+// matches draft-irtf-cfrg-xchacha's XChaCha20 for its first 256 GiB, and
+// like New's it continues with a 64-bit block counter beyond that.
+func NewX(key, nonce []byte) (ctx *Ctx) {
+	ctx, err := NewXWithError(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// NewXWithError is like NewX but returns ErrInvalidKeySize or
+// ErrInvalidNonceSize instead of panicking when key or nonce has an invalid
+// length.
+func NewXWithError(key, nonce []byte) (ctx *Ctx, err error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	if len(nonce) != 24 {
+		return nil, ErrInvalidNonceSize
+	}
+	return NewWithError(HChaCha20(key, nonce[:16]), nonce[16:])
+}
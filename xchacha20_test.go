@@ -0,0 +1,97 @@
+// xchacha20_test.go - test HChaCha20 and XChaCha20.
+//
+// DO NOT USE range. IT BREAKS OLDER GO VERSIONS.
+
+package chacha20
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"testing"
+)
+
+func TestHChaCha20(t *testing.T) {
+	// draft-irtf-cfrg-xchacha-03 section 2.2.1 test vector.
+	key := make([]byte, 32)
+	for i := 0; i < len(key); i++ {
+		key[i] = byte(i)
+	}
+	nonce := []byte{
+		0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x4a,
+		0x00, 0x00, 0x00, 0x00, 0x31, 0x41, 0x59, 0x27,
+	}
+	want := []byte{
+		0x82, 0x41, 0x3b, 0x42, 0x27, 0xb2, 0x7b, 0xfe,
+		0xd3, 0x0e, 0x42, 0x50, 0x8a, 0x87, 0x7d, 0x73,
+		0xa0, 0xf9, 0xe4, 0xd5, 0x8a, 0x74, 0xa8, 0x53,
+		0xc1, 0x2e, 0xc4, 0x13, 0x26, 0xd3, 0xec, 0xdc,
+	}
+	got := HChaCha20(key, nonce)
+	if !bytes.Equal(got, want) {
+		t.Errorf("HChaCha20:\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestXChaCha20(t *testing.T) {
+	// Key stream for the key and nonce of draft-irtf-cfrg-xchacha-03
+	// appendix A.3.2.
+	key := make([]byte, 32)
+	for i := 0; i < len(key); i++ {
+		key[i] = byte(0x80 + i)
+	}
+	nonce := []byte{
+		0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47,
+		0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f,
+		0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x58,
+	}
+	want := []byte{
+		0x11, 0x31, 0xce, 0x9a, 0x2a, 0x20, 0xae, 0x0d,
+		0x67, 0xc8, 0x93, 0x5c, 0x77, 0x89, 0xfa, 0x10,
+		0x25, 0xc9, 0xe5, 0xbb, 0x72, 0x0f, 0xb9, 0x6f,
+		0x11, 0x35, 0x4f, 0xb9, 0x7a, 0xf0, 0xbd, 0x9a,
+		0xad, 0xec, 0x08, 0x63, 0xba, 0x60, 0xca, 0xc8,
+		0x58, 0x2c, 0x48, 0xf8, 0x6c, 0xdf, 0xc4, 0x8e,
+		0xdd, 0x46, 0xa4, 0x86, 0x42, 0xc5, 0xde, 0x62,
+		0xcc, 0xf1, 0x1c, 0x7b, 0x21, 0xbf, 0x33, 0x7d,
+		0x29, 0x62, 0x4b, 0x4b, 0x1b, 0x14, 0x0a, 0xce,
+		0x53, 0x74, 0x0e, 0x40, 0x5b, 0x21, 0x68, 0x54,
+		0x0f, 0xd7, 0xd6, 0x30, 0xc1, 0xf5, 0x36, 0xfe,
+		0xcd, 0x72, 0x2f, 0xc3, 0xcd, 0xdb, 0xa7, 0xf4,
+		0xcc, 0xa9, 0x8c, 0xf9, 0xe4, 0x7e, 0x5e, 0x64,
+		0xd1, 0x15, 0x45, 0x0f, 0x9b, 0x12, 0x5b, 0x54,
+		0x44, 0x9f, 0xf7, 0x61, 0x41, 0xca, 0x62, 0x0a,
+		0x1f, 0x9c, 0xfc, 0xab, 0x2a, 0x1a, 0x8a, 0x25,
+	}
+	ctx := NewX(key, nonce)
+	got := make([]byte, len(want))
+	ctx.Keystream(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("XChaCha20 Keystream:\n got %x\nwant %x", got, want)
+	}
+
+	// Seek positions the XChaCha20 key stream like any other.
+	ctx.Seek(1)
+	got = make([]byte, blockLen)
+	ctx.Keystream(got)
+	if !bytes.Equal(got, want[blockLen:]) {
+		t.Errorf("XChaCha20 Seek(1):\n got %x\nwant %x", got, want[blockLen:])
+	}
+
+	// Parallel and non-parallel processing agree for every number of rounds.
+	rounds := []int{8, 12, 20}
+	p := make([]byte, 1_000_000)
+	np := make([]byte, len(p))
+	crand.Read(nonce)
+	for k := 0; k < len(rounds); k++ {
+		ctx = NewX(key, nonce)
+		ctx.SetRounds(rounds[k])
+		ctx.Read(p)
+		ctx = NewX(key, nonce)
+		ctx.SetRounds(rounds[k])
+		ctx.UseParallel(false)
+		ctx.Read(np)
+		if !bytes.Equal(p, np) {
+			t.Errorf("XChaCha%d parallel vs non-parallel: p != np", rounds[k])
+		}
+	}
+}